@@ -2,7 +2,4 @@ module github.com/yayuyokitano/rem-next/__postgres-init
 
 go 1.16
 
-require (
-	github.com/georgysavva/scany v0.3.0
-	github.com/jackc/pgx/v4 v4.14.1
-)
+require github.com/jackc/pgx/v4 v4.14.1
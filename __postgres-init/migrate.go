@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key shared by every migration run, so two runs can never interleave.
+const migrationLockKey = 719255152170762301
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type AppliedMigration struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time
+}
+
+func loadMigrations(files fs.FS) (migrations []Migration, err error) {
+
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			err = fmt.Errorf("Invalid migration file name %s", entry.Name())
+			return
+		}
+
+		var version int64
+		version, err = strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return
+		}
+
+		var raw []byte
+		raw, err = fs.ReadFile(files, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			err = fmt.Errorf("Migration %d has conflicting names %s and %s", version, m.Name, match[2])
+			return
+		}
+
+		if match[3] == "up" {
+			m.Up = string(raw)
+		} else {
+			m.Down = string(raw)
+		}
+	}
+
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			err = fmt.Errorf("Migration %d_%s must have both an up and a down file", m.Version, m.Name)
+			return
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, m := range migrations {
+		if m.Version != int64(i+1) {
+			err = fmt.Errorf("Migration versions must be sequential from 1, found %d at position %d", m.Version, i+1)
+			return
+		}
+	}
+
+	return
+
+}
+
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+}
+
+func newMigrator(ctx context.Context, conn *pgx.Conn) (m *Migrator, err error) {
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return
+	}
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations(
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		appliedAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return
+	}
+
+	m = &Migrator{
+		conn:       conn,
+		migrations: migrations,
+	}
+	return
+
+}
+
+// withLock holds a session level advisory lock for the duration of fn.
+// The lock is tied to the connection, so it is released even if the process dies mid-run.
+func (m *Migrator) withLock(ctx context.Context, fn func() error) (err error) {
+
+	_, err = m.conn.Exec(ctx, "SELECT pg_advisory_lock($1)", int64(migrationLockKey))
+	if err != nil {
+		return
+	}
+	defer func() {
+		_, unlockErr := m.conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", int64(migrationLockKey))
+		if err == nil {
+			err = unlockErr
+		}
+	}()
+
+	err = fn()
+	return
+
+}
+
+func (m *Migrator) Applied(ctx context.Context) (applied []AppliedMigration, err error) {
+
+	rows, err := m.conn.Query(ctx, "SELECT version, name, appliedAt FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a AppliedMigration
+		err = rows.Scan(&a.Version, &a.Name, &a.AppliedAt)
+		if err != nil {
+			return
+		}
+		applied = append(applied, a)
+	}
+	err = rows.Err()
+	return
+
+}
+
+func (m *Migrator) Current(ctx context.Context) (version int64, err error) {
+	err = m.conn.QueryRow(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return
+}
+
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.To(ctx, m.Latest())
+}
+
+func (m *Migrator) Down(ctx context.Context) (err error) {
+
+	current, err := m.Current(ctx)
+	if err != nil {
+		return
+	}
+	if current == 0 {
+		fmt.Println("No migrations to roll back")
+		return
+	}
+
+	err = m.To(ctx, current-1)
+	return
+
+}
+
+// To migrates up or down until the schema is at the target version.
+func (m *Migrator) To(ctx context.Context, target int64) error {
+
+	if target < 0 || target > m.Latest() {
+		return fmt.Errorf("Unknown migration version %d, latest is %d", target, m.Latest())
+	}
+
+	return m.withLock(ctx, func() (err error) {
+
+		// Read the version only once we hold the lock, another run may have just finished.
+		current, err := m.Current(ctx)
+		if err != nil {
+			return
+		}
+
+		for current < target {
+			err = m.apply(ctx, m.migrations[current], true)
+			if err != nil {
+				return
+			}
+			current++
+		}
+
+		for current > target {
+			err = m.apply(ctx, m.migrations[current-1], false)
+			if err != nil {
+				return
+			}
+			current--
+		}
+
+		return
+
+	})
+
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) (err error) {
+
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if up {
+		fmt.Printf("Applying %d_%s\n", migration.Version, migration.Name)
+		_, err = tx.Exec(ctx, migration.Up)
+		if err != nil {
+			err = fmt.Errorf("Migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			return
+		}
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", migration.Version, migration.Name)
+	} else {
+		fmt.Printf("Rolling back %d_%s\n", migration.Version, migration.Name)
+		_, err = tx.Exec(ctx, migration.Down)
+		if err != nil {
+			err = fmt.Errorf("Rollback of %d_%s failed: %w", migration.Version, migration.Name, err)
+			return
+		}
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	return
+
+}
+
+func (m *Migrator) Status(ctx context.Context) (err error) {
+
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return
+	}
+
+	appliedAt := make(map[int64]time.Time)
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	for _, migration := range m.migrations {
+		if at, ok := appliedAt[migration.Version]; ok {
+			fmt.Printf("%04d_%s\tapplied %s\n", migration.Version, migration.Name, at.Format(time.RFC3339))
+		} else {
+			fmt.Printf("%04d_%s\tpending\n", migration.Version, migration.Name)
+		}
+	}
+	return
+
+}
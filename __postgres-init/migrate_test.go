@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgx/v4"
+)
+
+func TestLoadMigrations(t *testing.T) {
+
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		t.Errorf("Failed to load embedded migrations: %s\n", err)
+		return
+	}
+	if len(migrations) == 0 {
+		t.Errorf("Expected at least one migration\n")
+		return
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"missing down": {
+			"migrations/0001_a.up.sql": {Data: []byte("SELECT 1;")},
+		},
+		"gap": {
+			"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+			"migrations/0003_c.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0003_c.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"conflicting names": {
+			"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		},
+		"bad name": {
+			"migrations/first.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+
+	for name, files := range invalid {
+		if _, err := loadMigrations(files); err == nil {
+			t.Errorf("%s: expected error, got none\n", name)
+		}
+	}
+
+}
+
+// TestMigrations applies and rolls back every migration, so it must only ever point at a disposable database.
+func TestMigrations(t *testing.T) {
+
+	databaseURL := os.Getenv("MIGRATION_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("MIGRATION_TEST_DATABASE_URL not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		t.Errorf("Failed to connect: %s\n", err)
+		return
+	}
+	defer conn.Close(ctx)
+
+	migrator, err := newMigrator(ctx, conn)
+	if err != nil {
+		t.Errorf("Failed to create migrator: %s\n", err)
+		return
+	}
+
+	if err = migrator.To(ctx, 0); err != nil {
+		t.Errorf("Failed to reset database: %s\n", err)
+		return
+	}
+
+	if err = migrator.Up(ctx); err != nil {
+		t.Errorf("Failed to apply migrations: %s\n", err)
+		return
+	}
+
+	current, err := migrator.Current(ctx)
+	if err != nil {
+		t.Errorf("Failed to read current version: %s\n", err)
+		return
+	}
+	if current != migrator.Latest() {
+		t.Errorf("Expected version %d, got %d\n", migrator.Latest(), current)
+		return
+	}
+
+	for current > 0 {
+		if err = migrator.Down(ctx); err != nil {
+			t.Errorf("Failed to roll back version %d: %s\n", current, err)
+			return
+		}
+		current--
+	}
+
+	applied, err := migrator.Applied(ctx)
+	if err != nil {
+		t.Errorf("Failed to read applied migrations: %s\n", err)
+		return
+	}
+	if len(applied) != 0 {
+		t.Errorf("Expected no applied migrations, got %d\n", len(applied))
+		return
+	}
+
+	// Applying again after a full rollback catches down migrations that leave objects behind.
+	if err = migrator.Up(ctx); err != nil {
+		t.Errorf("Failed to reapply migrations: %s\n", err)
+		return
+	}
+
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS guildXP;
+DROP TABLE IF EXISTS commands;
+DROP TABLE IF EXISTS channelblocklist;
+DROP TABLE IF EXISTS rolerewards;
+DROP TABLE IF EXISTS guilds;
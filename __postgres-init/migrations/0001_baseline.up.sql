@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS guilds(
+	guildID VARCHAR(20) PRIMARY KEY
+);
+ALTER TABLE guilds ADD COLUMN IF NOT EXISTS cumulativeRoles BOOL NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS rolerewards(
+	guildID VARCHAR(20) NOT NULL,
+	roleID VARCHAR(20) NOT NULL,
+	level INTEGER NOT NULL,
+	color INTEGER NOT NULL
+);
+ALTER TABLE rolerewards ADD COLUMN IF NOT EXISTS persistent BOOL NOT NULL DEFAULT FALSE;
+CREATE UNIQUE INDEX IF NOT EXISTS rolerewardidx ON rolerewards(guildID, roleID, level);
+
+CREATE TABLE IF NOT EXISTS channelblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	channelID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS channelguildid ON channelblocklist(guildID);
+
+CREATE TABLE IF NOT EXISTS commands(
+	commandID VARCHAR(20) PRIMARY KEY,
+	guildID VARCHAR(20) NOT NULL,
+	commandName VARCHAR(32) NOT NULL
+);
+CREATE INDEX IF NOT EXISTS command ON commands(guildID, commandName);
+
+CREATE TABLE IF NOT EXISTS guildXP(
+	guildID VARCHAR(20) NOT NULL,
+	userID VARCHAR(20) NOT NULL,
+	nickname VARCHAR(32) NOT NULL,
+	avatar VARCHAR(34) NOT NULL,
+	xp BIGINT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS xplookup ON guildXP(guildID, userID);
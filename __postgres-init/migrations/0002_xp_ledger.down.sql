@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS xpledger;
+DROP FUNCTION IF EXISTS xpledger_append_only();
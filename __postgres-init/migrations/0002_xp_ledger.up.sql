@@ -0,0 +1,21 @@
+CREATE TABLE xpledger(
+	id BIGSERIAL PRIMARY KEY,
+	guildID VARCHAR(20) NOT NULL,
+	userID VARCHAR(20) NOT NULL,
+	delta BIGINT NOT NULL,
+	total BIGINT NOT NULL,
+	source VARCHAR(32) NOT NULL,
+	actorID VARCHAR(20),
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX xpledgerhistory ON xpledger(guildID, userID, id DESC);
+
+-- The ledger is the only record of why XP changed, so it must never be rewritten.
+CREATE FUNCTION xpledger_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'xpledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER xpledgerappendonly BEFORE UPDATE OR DELETE ON xpledger
+	FOR EACH ROW EXECUTE PROCEDURE xpledger_append_only();
@@ -0,0 +1,10 @@
+CREATE TABLE guildsettings(
+	guildID VARCHAR(20) PRIMARY KEY,
+	xpMin INTEGER NOT NULL DEFAULT 15,
+	xpMax INTEGER NOT NULL DEFAULT 25,
+	cooldown INTEGER NOT NULL DEFAULT 60,
+	levelUpMessage VARCHAR(2000) NOT NULL DEFAULT '',
+	announceChannelID VARCHAR(20) NOT NULL DEFAULT '',
+	CHECK (xpMin >= 0 AND xpMin <= xpMax),
+	CHECK (cooldown >= 0)
+);
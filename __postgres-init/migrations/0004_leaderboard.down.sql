@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS guildxpranking;
+ALTER TABLE guildsettings DROP COLUMN IF EXISTS publicLeaderboard;
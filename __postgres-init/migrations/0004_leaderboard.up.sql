@@ -0,0 +1,2 @@
+ALTER TABLE guildsettings ADD COLUMN publicLeaderboard BOOL NOT NULL DEFAULT FALSE;
+CREATE INDEX guildxpranking ON guildXP(guildID, xp DESC, userID);
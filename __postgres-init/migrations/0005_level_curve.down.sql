@@ -0,0 +1 @@
+ALTER TABLE guildsettings DROP COLUMN IF EXISTS levelCurve;
@@ -0,0 +1 @@
+ALTER TABLE guildsettings ADD COLUMN levelCurve JSONB NOT NULL DEFAULT '{"type":"mee6"}';
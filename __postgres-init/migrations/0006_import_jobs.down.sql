@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS import_job_rolerewards;
+DROP TABLE IF EXISTS import_job_users;
+DROP TABLE IF EXISTS import_jobs;
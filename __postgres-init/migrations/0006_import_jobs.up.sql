@@ -0,0 +1,40 @@
+CREATE TABLE import_jobs(
+	id BIGSERIAL PRIMARY KEY,
+	guildID VARCHAR(20) NOT NULL,
+	callerID VARCHAR(20) NOT NULL,
+	source VARCHAR(32) NOT NULL,
+	-- Kept until the job finishes so the worker can resume, then cleared.
+	apiKey TEXT NOT NULL DEFAULT '',
+	merge VARCHAR(16) NOT NULL DEFAULT '',
+	dryRun BOOL NOT NULL DEFAULT FALSE,
+	status VARCHAR(16) NOT NULL DEFAULT 'queued',
+	-- The first page that has not been staged yet, workers resume from here.
+	nextPage INTEGER NOT NULL DEFAULT 0,
+	usersImported BIGINT NOT NULL DEFAULT 0,
+	errors TEXT[] NOT NULL DEFAULT '{}',
+	result JSONB,
+	leaseUntil TIMESTAMPTZ,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	CHECK (status IN ('queued', 'running', 'done', 'failed'))
+);
+CREATE INDEX importjobsguild ON import_jobs(guildID, id DESC);
+
+-- Pages are staged here as they are fetched and only copied into guildXP once the last page is in.
+CREATE TABLE import_job_users(
+	jobID BIGINT NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+	userID VARCHAR(20) NOT NULL,
+	nickname VARCHAR(32) NOT NULL,
+	avatar VARCHAR(34) NOT NULL,
+	xp BIGINT NOT NULL,
+	PRIMARY KEY (jobID, userID)
+);
+
+CREATE TABLE import_job_rolerewards(
+	jobID BIGINT NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE,
+	roleID VARCHAR(20) NOT NULL,
+	level INTEGER NOT NULL,
+	color INTEGER NOT NULL,
+	persistent BOOL NOT NULL,
+	PRIMARY KEY (jobID, roleID, level)
+);
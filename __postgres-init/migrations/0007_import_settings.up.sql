@@ -0,0 +1,2 @@
+-- Settings read from the source bot, applied together with the levels once the job finishes.
+ALTER TABLE import_jobs ADD COLUMN settings JSONB;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS levelsnapshotrolerewards;
+DROP TABLE IF EXISTS levelsnapshotxp;
+DROP TABLE IF EXISTS levelsnapshots;
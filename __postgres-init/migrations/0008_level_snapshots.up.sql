@@ -0,0 +1,29 @@
+-- Copies of a guild's levels taken before anything wipes or overwrites them.
+CREATE TABLE levelsnapshots(
+	id BIGSERIAL PRIMARY KEY,
+	guildID VARCHAR(20) NOT NULL,
+	reason VARCHAR(32) NOT NULL,
+	actorID VARCHAR(20),
+	users INTEGER NOT NULL DEFAULT 0,
+	roleRewards INTEGER NOT NULL DEFAULT 0,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX levelsnapshotsguild ON levelsnapshots(guildID, id DESC);
+
+CREATE TABLE levelsnapshotxp(
+	snapshotID BIGINT NOT NULL REFERENCES levelsnapshots(id) ON DELETE CASCADE,
+	userID VARCHAR(20) NOT NULL,
+	nickname VARCHAR(32) NOT NULL,
+	avatar VARCHAR(34) NOT NULL,
+	xp BIGINT NOT NULL,
+	PRIMARY KEY (snapshotID, userID)
+);
+
+CREATE TABLE levelsnapshotrolerewards(
+	snapshotID BIGINT NOT NULL REFERENCES levelsnapshots(id) ON DELETE CASCADE,
+	roleID VARCHAR(20) NOT NULL,
+	level INTEGER NOT NULL,
+	color INTEGER NOT NULL,
+	persistent BOOL NOT NULL,
+	PRIMARY KEY (snapshotID, roleID, level)
+);
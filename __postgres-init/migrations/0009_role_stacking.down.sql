@@ -0,0 +1,3 @@
+ALTER TABLE guilds DROP CONSTRAINT IF EXISTS guildsrolestacking;
+ALTER TABLE guilds DROP COLUMN IF EXISTS roleStackingKeep;
+ALTER TABLE guilds DROP COLUMN IF EXISTS roleStacking;
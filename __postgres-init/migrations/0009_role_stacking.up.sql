@@ -0,0 +1,5 @@
+-- How role rewards stack as members level up. cumulativeRoles is kept in sync for the bot versions that only read it.
+ALTER TABLE guilds ADD COLUMN roleStacking VARCHAR(16) NOT NULL DEFAULT 'highest';
+ALTER TABLE guilds ADD COLUMN roleStackingKeep INTEGER NOT NULL DEFAULT 1;
+UPDATE guilds SET roleStacking = 'cumulative' WHERE cumulativeRoles;
+ALTER TABLE guilds ADD CONSTRAINT guildsrolestacking CHECK (roleStacking IN ('cumulative', 'highest', 'keepLast') AND roleStackingKeep >= 1);
@@ -0,0 +1,22 @@
+-- Jobs that hand out and take away reward roles so members match what their xp qualifies them for.
+CREATE TABLE rolesyncjobs(
+	id BIGSERIAL PRIMARY KEY,
+	guildID VARCHAR(20) NOT NULL,
+	callerID VARCHAR(20) NOT NULL,
+	dryRun BOOL NOT NULL DEFAULT FALSE,
+	status VARCHAR(16) NOT NULL DEFAULT 'queued',
+	-- Members are gone through in ID order, workers resume after this one.
+	afterMember VARCHAR(20) NOT NULL DEFAULT '0',
+	membersChecked INTEGER NOT NULL DEFAULT 0,
+	membersChanged INTEGER NOT NULL DEFAULT 0,
+	rolesAdded INTEGER NOT NULL DEFAULT 0,
+	rolesRemoved INTEGER NOT NULL DEFAULT 0,
+	-- What would change for each member, only filled in for dry runs and capped in size.
+	changes JSONB NOT NULL DEFAULT '[]',
+	errors TEXT[] NOT NULL DEFAULT '{}',
+	leaseUntil TIMESTAMPTZ,
+	createdAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	updatedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	CHECK (status IN ('queued', 'running', 'done', 'failed'))
+);
+CREATE INDEX rolesyncjobsguild ON rolesyncjobs(guildID, id DESC);
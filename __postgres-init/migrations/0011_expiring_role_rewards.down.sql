@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS xpledgerrecent;
+DROP TABLE IF EXISTS rolerewardgrants;
+ALTER TABLE levelsnapshotrolerewards DROP COLUMN IF EXISTS topWindowDays;
+ALTER TABLE levelsnapshotrolerewards DROP COLUMN IF EXISTS topN;
+ALTER TABLE levelsnapshotrolerewards DROP COLUMN IF EXISTS expiresAfter;
+ALTER TABLE rolerewards DROP CONSTRAINT IF EXISTS rolerewardsexpiry;
+ALTER TABLE rolerewards DROP COLUMN IF EXISTS topWindowDays;
+ALTER TABLE rolerewards DROP COLUMN IF EXISTS topN;
+ALTER TABLE rolerewards DROP COLUMN IF EXISTS expiresAfter;
@@ -0,0 +1,29 @@
+-- Rewards can lapse a while after they are handed out, or once the member drops out of the top of the leaderboard.
+ALTER TABLE rolerewards ADD COLUMN expiresAfter INTEGER;
+ALTER TABLE rolerewards ADD COLUMN topN INTEGER;
+-- Ranks by xp gained over this many days instead of total xp.
+ALTER TABLE rolerewards ADD COLUMN topWindowDays INTEGER;
+ALTER TABLE rolerewards ADD CONSTRAINT rolerewardsexpiry CHECK (
+	(expiresAfter IS NULL OR expiresAfter > 0)
+	AND (topN IS NULL OR topN > 0)
+	AND (topWindowDays IS NULL OR (topWindowDays > 0 AND topN IS NOT NULL))
+);
+
+ALTER TABLE levelsnapshotrolerewards ADD COLUMN expiresAfter INTEGER;
+ALTER TABLE levelsnapshotrolerewards ADD COLUMN topN INTEGER;
+ALTER TABLE levelsnapshotrolerewards ADD COLUMN topWindowDays INTEGER;
+
+-- When each expiring reward was handed out, so the expiry job knows what to take back.
+CREATE TABLE rolerewardgrants(
+	guildID VARCHAR(20) NOT NULL,
+	userID VARCHAR(20) NOT NULL,
+	roleID VARCHAR(20) NOT NULL,
+	grantedAt TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expiresAt TIMESTAMPTZ,
+	revokedAt TIMESTAMPTZ,
+	PRIMARY KEY (guildID, userID, roleID)
+);
+CREATE INDEX rolerewardgrantsexpiry ON rolerewardgrants(expiresAt) WHERE revokedAt IS NULL;
+
+-- Ranking by recent xp sums the ledger per guild over a time range.
+CREATE INDEX xpledgerrecent ON xpledger(guildID, createdAt);
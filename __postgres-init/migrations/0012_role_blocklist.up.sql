@@ -0,0 +1,7 @@
+-- Members with a blocked role gain no xp, whichever channel they talk in.
+CREATE TABLE roleblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	roleID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX roleguildid ON roleblocklist(guildID);
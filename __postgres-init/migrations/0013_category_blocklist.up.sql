@@ -0,0 +1,7 @@
+-- Blocking a category covers every channel in it, including ones created later.
+CREATE TABLE categoryblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	categoryID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX categoryguildid ON categoryblocklist(guildID);
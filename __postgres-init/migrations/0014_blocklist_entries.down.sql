@@ -0,0 +1,25 @@
+-- Only xpgain had a column before, every other list type is lost.
+CREATE TABLE IF NOT EXISTS channelblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	channelID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS channelguildid ON channelblocklist(guildID);
+CREATE TABLE IF NOT EXISTS categoryblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	categoryID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS categoryguildid ON categoryblocklist(guildID);
+CREATE TABLE IF NOT EXISTS roleblocklist(
+	guildID VARCHAR(20) NOT NULL,
+	roleID VARCHAR(20) PRIMARY KEY,
+	xpgain bool NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS roleguildid ON roleblocklist(guildID);
+
+INSERT INTO channelblocklist (guildID, channelID, xpgain) SELECT guildID, targetID, state FROM blocklistentries WHERE targetKind = 'channel' AND listType = 'xpgain' ON CONFLICT DO NOTHING;
+INSERT INTO categoryblocklist (guildID, categoryID, xpgain) SELECT guildID, targetID, state FROM blocklistentries WHERE targetKind = 'category' AND listType = 'xpgain' ON CONFLICT DO NOTHING;
+INSERT INTO roleblocklist (guildID, roleID, xpgain) SELECT guildID, targetID, state FROM blocklistentries WHERE targetKind = 'role' AND listType = 'xpgain' ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS blocklistentries;
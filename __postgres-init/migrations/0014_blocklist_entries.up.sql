@@ -0,0 +1,19 @@
+-- One row per target and list type, so new list types don't need a column each.
+-- The list types match the registry in blocklist, which remraku is sent with every change.
+CREATE TABLE blocklistentries(
+	guildID VARCHAR(20) NOT NULL,
+	targetKind VARCHAR(16) NOT NULL CHECK (targetKind IN ('channel', 'category', 'role')),
+	targetID VARCHAR(20) NOT NULL,
+	listType VARCHAR(32) NOT NULL CHECK (listType IN ('xpgain', 'levelup', 'commands', 'rankcard')),
+	state BOOL NOT NULL DEFAULT FALSE,
+	PRIMARY KEY (targetKind, targetID, listType)
+);
+CREATE INDEX blocklistentriesguild ON blocklistentries(guildID);
+
+INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) SELECT guildID, 'channel', channelID, 'xpgain', xpgain FROM channelblocklist;
+INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) SELECT guildID, 'category', categoryID, 'xpgain', xpgain FROM categoryblocklist;
+INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) SELECT guildID, 'role', roleID, 'xpgain', xpgain FROM roleblocklist;
+
+DROP TABLE channelblocklist;
+DROP TABLE categoryblocklist;
+DROP TABLE roleblocklist;
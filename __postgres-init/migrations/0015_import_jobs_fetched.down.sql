@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS importjobsunfinished;
+ALTER TABLE import_jobs DROP COLUMN IF EXISTS fetched;
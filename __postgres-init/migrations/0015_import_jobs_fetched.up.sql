@@ -0,0 +1,3 @@
+-- Set once the last page is staged, the API key is cleared at the same time since merging doesn't need it.
+ALTER TABLE import_jobs ADD COLUMN fetched BOOL NOT NULL DEFAULT FALSE;
+CREATE INDEX importjobsunfinished ON import_jobs(leaseUntil) WHERE status IN ('queued', 'running');
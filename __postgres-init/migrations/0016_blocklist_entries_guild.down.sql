@@ -0,0 +1,7 @@
+CREATE INDEX blocklistentriesguild ON blocklistentries(guildID);
+-- Several guilds may have an entry for the same target by now, the older key only has room for one of them.
+-- The entry of the guild with the lowest ID is kept, the others are lost.
+DELETE FROM blocklistentries a USING blocklistentries b
+	WHERE a.targetKind = b.targetKind AND a.targetID = b.targetID AND a.listType = b.listType AND a.guildID > b.guildID;
+ALTER TABLE blocklistentries DROP CONSTRAINT blocklistentries_pkey;
+ALTER TABLE blocklistentries ADD PRIMARY KEY (targetKind, targetID, listType);
@@ -0,0 +1,4 @@
+-- Entries belong to the guild that set them, so no guild can overwrite another's entry for the same target.
+ALTER TABLE blocklistentries DROP CONSTRAINT blocklistentries_pkey;
+ALTER TABLE blocklistentries ADD PRIMARY KEY (guildID, targetKind, targetID, listType);
+DROP INDEX IF EXISTS blocklistentriesguild;
@@ -0,0 +1,2 @@
+-- The sweeper looks for unfinished jobs whose worker is gone.
+CREATE INDEX rolesyncjobsunfinished ON rolesyncjobs(leaseUntil) WHERE status IN ('queued', 'running');
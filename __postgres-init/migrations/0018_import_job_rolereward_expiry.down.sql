@@ -0,0 +1,3 @@
+ALTER TABLE import_job_rolerewards DROP COLUMN IF EXISTS topWindowDays;
+ALTER TABLE import_job_rolerewards DROP COLUMN IF EXISTS topN;
+ALTER TABLE import_job_rolerewards DROP COLUMN IF EXISTS expiresAfter;
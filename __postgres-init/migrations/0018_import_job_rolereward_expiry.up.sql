@@ -0,0 +1,4 @@
+-- Staged role rewards keep their expiry, so a job imports them the same as a file would.
+ALTER TABLE import_job_rolerewards ADD COLUMN expiresAfter INTEGER;
+ALTER TABLE import_job_rolerewards ADD COLUMN topN INTEGER;
+ALTER TABLE import_job_rolerewards ADD COLUMN topWindowDays INTEGER;
@@ -13,6 +13,7 @@ import (
 	"cloud.google.com/go/pubsub"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/yayuyokitano/rem-next/listtype"
 )
 
 func init() {
@@ -49,19 +50,58 @@ func corsHandler(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+// Kinds of blocklist targets, channels are assumed when none is given.
+// Categories are blocked for every channel in them, a channel's own entry beats its category's.
+const (
+	kindChannel  = "channel"
+	kindCategory = "category"
+	kindRole     = "role"
+)
+
 type Params struct {
-	GuildID   string `json:"guildID"`
-	ChannelID string `json:"channelID"`
-	Token     int64  `json:"token"`
-	UserID    string `json:"userID"`
-	ListType  string `json:"listType"`
-	State     bool   `json:"state"`
+	GuildID    string        `json:"guildID"`
+	Kind       string        `json:"kind"`
+	ChannelID  string        `json:"channelID"`
+	CategoryID string        `json:"categoryID"`
+	RoleID     string        `json:"roleID"`
+	Token      int64         `json:"token"`
+	UserID     string        `json:"userID"`
+	ListType   listtype.Type `json:"listType"`
+	State      bool          `json:"state"`
+}
+
+// target returns the ID of the channel, category or role the request is about.
+func (p Params) target() (targetID string, err error) {
+	return BlocklistEntry{Kind: p.Kind, ChannelID: p.ChannelID, CategoryID: p.CategoryID, RoleID: p.RoleID}.target()
 }
 
 func blocklist(writer http.ResponseWriter, request *http.Request) {
 
 	corsHandler(writer, request)
 
+	switch request.Method {
+	case "GET":
+		getBlocklist(writer, request)
+		break
+	case "POST":
+		postBlocklist(writer, request)
+		break
+	case "PATCH":
+		patchBlocklist(writer, request)
+		break
+	case "DELETE":
+		deleteBlocklist(writer, request)
+		break
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(writer, "Method not allowed")
+		break
+	}
+
+}
+
+func postBlocklist(writer http.ResponseWriter, request *http.Request) {
+
 	var params Params
 
 	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
@@ -69,7 +109,13 @@ func blocklist(writer http.ResponseWriter, request *http.Request) {
 		fmt.Fprint(writer, "Failed to decode request body", err)
 		return
 	}
-	if params.GuildID == "" || params.ChannelID == "" || params.Token == 0 || params.UserID == "" {
+	targetID, err := params.target()
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+	if params.GuildID == "" || targetID == "" || params.Token == 0 || params.UserID == "" {
 		writer.WriteHeader(http.StatusBadRequest)
 		fmt.Fprint(writer, "Missing parameters")
 		return
@@ -81,13 +127,44 @@ func blocklist(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	if err := pushToDB(params.GuildID, params.ChannelID, params.ListType, params.State, request); err != nil {
+	switch params.Kind {
+	case kindRole:
+		postRoleBlocklist(writer, request, params)
+		return
+	case kindCategory:
+		postCategoryBlocklist(writer, request, params)
+		return
+	}
+
+	// Channels that were deleted since can still be taken off the blocklist.
+	if params.State {
+		channels, err := fetchGuildChannels(request.Context(), params.GuildID)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to fetch guild channels: ", err)
+			return
+		}
+		if err := validateChannel(params.ChannelID, channels); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+	}
+
+	if err := pushToDB(params.GuildID, kindChannel, params.ChannelID, params.ListType, params.State, request); err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(writer, "Failed to push to DB: ", err)
 		return
 	}
 
-	if err := pushToRemraku(params.GuildID, params.ChannelID, params.ListType, params.State, request); err != nil {
+	if err := publishToRemraku(request.Context(), blocklistMessage{
+		Type:      "blocklist",
+		GuildID:   params.GuildID,
+		ChannelID: params.ChannelID,
+		ListType:  params.ListType,
+		State:     params.State,
+		ListTypes: listtype.Names(),
+	}); err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
 		return
@@ -114,46 +191,66 @@ func confirmPermission(guildID string, callerID string, token int64) (err error)
 	return
 }
 
-func pushToDB(guildID string, channelID string, listType string, state bool, request *http.Request) (err error) {
+var (
+	errUnknownChannel = errors.New("Channel does not exist on the guild")
+	errIsCategory     = errors.New("Channel is a category, block it as a category instead")
+)
+
+func validateChannel(channelID string, channels []DiscordChannel) (err error) {
+
+	for _, channel := range channels {
+		if channel.ID != channelID {
+			continue
+		}
+		if channel.Type == channelTypeCategory {
+			return errIsCategory
+		}
+		return
+	}
+	return errUnknownChannel
+
+}
+
+// pushToDB sets the state of a channel, category or role on one list.
+func pushToDB(guildID string, kind string, targetID string, listType listtype.Type, state bool, request *http.Request) (err error) {
 
 	err = createPool()
 	if err != nil {
 		return
 	}
 
-	if !contains([]string{"xpgain"}, listType) {
-		err = errors.New("Invalid list type")
+	if !listtype.Valid(listType) {
+		err = listtype.ErrInvalid
 		return
 	}
 
-	_, err = pool.Exec(request.Context(), fmt.Sprintf("INSERT INTO channelblocklist (guildID, channelID, %s) VALUES ($1, $2, $3) ON CONFLICT (channelID) DO UPDATE SET %s = $3", listType, listType), guildID, channelID, state)
+	_, err = pool.Exec(request.Context(), upsertEntryQuery, guildID, kind, targetID, listType, state)
 
 	return
 
 }
 
+const upsertEntryQuery = `INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (guildID, targetKind, targetID, listType) DO UPDATE SET state = $5`
+
 type blocklistMessage struct {
-	Type      string `json:"type"`
-	GuildID   string `json:"guildID"`
-	ChannelID string `json:"channelID"`
-	ListType  string `json:"listType"`
-	State     bool   `json:"state"`
+	Type      string          `json:"type"`
+	GuildID   string          `json:"guildID"`
+	ChannelID string          `json:"channelID"`
+	ListType  listtype.Type   `json:"listType"`
+	State     bool            `json:"state"`
+	ListTypes []listtype.Type `json:"listTypes"`
 }
 
-func pushToRemraku(guildID string, channelID string, listType string, state bool, request *http.Request) (err error) {
+// publishToRemraku sends one of the blocklist messages to the bot.
+func publishToRemraku(ctx context.Context, message interface{}) (err error) {
 
 	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
 	if err != nil {
 		return
 	}
 
-	pubsubRaw, err := json.Marshal(blocklistMessage{
-		Type:      "blocklist",
-		GuildID:   guildID,
-		ChannelID: channelID,
-		ListType:  listType,
-		State:     state,
-	})
+	pubsubRaw, err := json.Marshal(message)
 	if err != nil {
 		return
 	}
@@ -162,8 +259,7 @@ func pushToRemraku(guildID string, channelID string, listType string, state bool
 		Data: pubsubRaw,
 	}
 
-	_, err = client.Topic("remraku").Publish(request.Context(), m).Get(request.Context())
-
+	_, err = client.Topic("remraku").Publish(ctx, m).Get(ctx)
 	return
 
 }
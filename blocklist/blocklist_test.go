@@ -197,7 +197,7 @@ func handlePubsub(msg *pubsub.Message, t *testing.T) (state bool, err error) {
 
 func checkSQL() (r bool, err error) {
 
-	row := pool.QueryRow(context.Background(), "SELECT xpgain FROM channelblocklist WHERE channelID = $1", os.Getenv("REM_TEST_CHANNELID"))
+	row := pool.QueryRow(context.Background(), "SELECT state FROM blocklistentries WHERE targetKind = 'channel' AND targetID = $1 AND listType = 'xpgain'", os.Getenv("REM_TEST_CHANNELID"))
 	err = row.Scan(&r)
 
 	return
@@ -0,0 +1,232 @@
+package remblocklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+const maxBulkChanges = 500
+
+type BulkParams struct {
+	GuildID string           `json:"guildID"`
+	Token   int64            `json:"token"`
+	UserID  string           `json:"userID"`
+	Changes []BlocklistEntry `json:"changes"`
+}
+
+type entryKey struct {
+	kind     string
+	targetID string
+	listType listtype.Type
+}
+
+func patchBlocklist(writer http.ResponseWriter, request *http.Request) {
+
+	var params BulkParams
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	if params.GuildID == "" || params.Token == 0 || params.UserID == "" || len(params.Changes) == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	changes, err := normalizeChanges(params.Changes)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+
+	err = checkBlockedTargets(request.Context(), params.GuildID, changes)
+	var targetErr *targetChangeError
+	if errors.As(err, &targetErr) {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch guild from Discord: ", err)
+		return
+	}
+
+	if err := createPool(); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to create pool: ", err)
+		return
+	}
+
+	ctx := request.Context()
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to begin transaction: ", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	for _, change := range changes {
+		targetID, _ := change.target()
+		_, err = tx.Exec(ctx, upsertEntryQuery, params.GuildID, change.Kind, targetID, change.ListType, change.State)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to push to DB: ", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	// Only published once committed, so the bot is never told about entries the DB doesn't have.
+	if err := publishToRemraku(ctx, bulkBlocklistMessage{
+		Type:      "blocklistbulk",
+		GuildID:   params.GuildID,
+		Changes:   changes,
+		ListTypes: listtype.Names(),
+	}); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(changes)
+
+}
+
+// normalizeChanges validates every change and spells out its kind, so remraku never has to guess.
+func normalizeChanges(changes []BlocklistEntry) (normalized []BlocklistEntry, err error) {
+
+	if len(changes) > maxBulkChanges {
+		err = fmt.Errorf("At most %d changes can be made at once", maxBulkChanges)
+		return
+	}
+
+	seen := make(map[entryKey]bool, len(changes))
+	normalized = make([]BlocklistEntry, 0, len(changes))
+	for _, change := range changes {
+		targetID, targetErr := change.target()
+		if targetErr != nil {
+			err = targetErr
+			return
+		}
+		if _, parseErr := strconv.ParseUint(targetID, 10, 64); parseErr != nil || len(targetID) < 17 || len(targetID) > 20 {
+			err = fmt.Errorf("Invalid %s %q", kindOrChannel(change.Kind), targetID)
+			return
+		}
+		if !listtype.Valid(change.ListType) {
+			err = fmt.Errorf("Invalid list type %q", change.ListType)
+			return
+		}
+
+		entry := BlocklistEntry{Kind: kindOrChannel(change.Kind), ListType: change.ListType, State: change.State}
+		entry.setTarget(targetID)
+
+		key := entryKey{entry.Kind, targetID, entry.ListType}
+		if seen[key] {
+			err = fmt.Errorf("%s %s is changed on %s more than once", entry.Kind, targetID, entry.ListType)
+			return
+		}
+		seen[key] = true
+		normalized = append(normalized, entry)
+	}
+	return
+
+}
+
+func kindOrChannel(kind string) string {
+	if kind == "" {
+		return kindChannel
+	}
+	return kind
+}
+
+type targetChangeError struct {
+	Kind     string
+	TargetID string
+	Err      error
+}
+
+func (e *targetChangeError) Error() string {
+	return fmt.Sprintf("%s %s: %s", e.Kind, e.TargetID, e.Err)
+}
+
+// checkBlockedTargets validates the targets being blocked against the guild, Discord is only asked for what the changes need.
+func checkBlockedTargets(ctx context.Context, guildID string, changes []BlocklistEntry) (err error) {
+
+	var roles []DiscordRole
+	var channels []DiscordChannel
+	rolesFetched, channelsFetched := false, false
+	for _, change := range changes {
+		if !change.State {
+			continue
+		}
+		var targetErr error
+		switch change.Kind {
+		case kindRole:
+			if !rolesFetched {
+				roles, err = fetchGuildRoles(ctx, guildID)
+				if err != nil {
+					return
+				}
+				rolesFetched = true
+			}
+			targetErr = validateRole(guildID, change.RoleID, roles)
+			break
+		case kindCategory:
+			if !channelsFetched {
+				channels, err = fetchGuildChannels(ctx, guildID)
+				if err != nil {
+					return
+				}
+				channelsFetched = true
+			}
+			targetErr = validateCategory(change.CategoryID, channels)
+			break
+		default:
+			if !channelsFetched {
+				channels, err = fetchGuildChannels(ctx, guildID)
+				if err != nil {
+					return
+				}
+				channelsFetched = true
+			}
+			targetErr = validateChannel(change.ChannelID, channels)
+			break
+		}
+		if targetErr != nil {
+			targetID, _ := change.target()
+			return &targetChangeError{Kind: change.Kind, TargetID: targetID, Err: targetErr}
+		}
+	}
+	return
+
+}
+
+type bulkBlocklistMessage struct {
+	Type      string           `json:"type"`
+	GuildID   string           `json:"guildID"`
+	Changes   []BlocklistEntry `json:"changes"`
+	ListTypes []listtype.Type  `json:"listTypes"`
+}
@@ -0,0 +1,43 @@
+package remblocklist
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+func TestNormalizeChanges(t *testing.T) {
+
+	changes := []BlocklistEntry{
+		{ChannelID: "956209277926768650", ListType: "xpgain", State: true},
+		{Kind: kindRole, ChannelID: "956209277926768650", RoleID: "956209277926768700", ListType: "xpgain"},
+		{ChannelID: "956209277926768650", ListType: listtype.LevelUp, State: true},
+	}
+	got, err := normalizeChanges(changes)
+	if err != nil {
+		t.Errorf("Expected changes to be valid, got %s\n", err)
+		return
+	}
+	want := []BlocklistEntry{
+		{Kind: kindChannel, ChannelID: "956209277926768650", ListType: "xpgain", State: true},
+		{Kind: kindRole, RoleID: "956209277926768700", ListType: "xpgain"},
+		{Kind: kindChannel, ChannelID: "956209277926768650", ListType: listtype.LevelUp, State: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v\n", want, got)
+	}
+
+	invalid := map[string][]BlocklistEntry{
+		"unknown kind":    {{Kind: "user", ChannelID: "956209277926768650", ListType: "xpgain"}},
+		"invalid target":  {{ChannelID: "general", ListType: "xpgain"}},
+		"invalid list":    {{ChannelID: "956209277926768650", ListType: "xpgai"}},
+		"duplicate entry": {changes[0], {Kind: kindChannel, ChannelID: "956209277926768650", ListType: "xpgain"}},
+	}
+	for name, changes := range invalid {
+		if _, err := normalizeChanges(changes); err == nil {
+			t.Errorf("Expected %s to be rejected\n", name)
+		}
+	}
+
+}
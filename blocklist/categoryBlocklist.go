@@ -0,0 +1,76 @@
+package remblocklist
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+var (
+	errUnknownCategory = errors.New("Category does not exist on the guild")
+	errNotCategory     = errors.New("Channel is not a category, block it as a channel instead")
+)
+
+func postCategoryBlocklist(writer http.ResponseWriter, request *http.Request, params Params) {
+
+	// Categories that were deleted since can still be taken off the blocklist.
+	if params.State {
+		channels, err := fetchGuildChannels(request.Context(), params.GuildID)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to fetch guild channels: ", err)
+			return
+		}
+		if err := validateCategory(params.CategoryID, channels); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+	}
+
+	if err := pushToDB(params.GuildID, kindCategory, params.CategoryID, params.ListType, params.State, request); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	if err := publishToRemraku(request.Context(), categoryBlocklistMessage{
+		Type:       "categoryblocklist",
+		GuildID:    params.GuildID,
+		CategoryID: params.CategoryID,
+		ListType:   params.ListType,
+		State:      params.State,
+		ListTypes:  listtype.Names(),
+	}); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+}
+
+func validateCategory(categoryID string, channels []DiscordChannel) (err error) {
+
+	for _, channel := range channels {
+		if channel.ID != categoryID {
+			continue
+		}
+		if channel.Type != channelTypeCategory {
+			return errNotCategory
+		}
+		return
+	}
+	return errUnknownCategory
+
+}
+
+type categoryBlocklistMessage struct {
+	Type       string          `json:"type"`
+	GuildID    string          `json:"guildID"`
+	CategoryID string          `json:"categoryID"`
+	ListType   listtype.Type   `json:"listType"`
+	State      bool            `json:"state"`
+	ListTypes  []listtype.Type `json:"listTypes"`
+}
@@ -0,0 +1,116 @@
+package remblocklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+var errEntryNotFound = errors.New("Blocklist entry not found")
+
+// deleteBlocklist clears an entry, so a channel follows its category again and anything else goes back to the default.
+// Setting the state to false can't do that, a channel's own entry always beats its category's.
+func deleteBlocklist(writer http.ResponseWriter, request *http.Request) {
+
+	var params Params
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	targetID, err := params.target()
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+	if params.GuildID == "" || targetID == "" || params.Token == 0 || params.UserID == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+	if !listtype.Valid(params.ListType) {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", listtype.ErrInvalid)
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	kind := kindOrChannel(params.Kind)
+	err = clearEntry(request.Context(), params.GuildID, kind, targetID, params.ListType)
+	if errors.Is(err, errEntryNotFound) {
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(writer, err)
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	message := clearBlocklistMessage{
+		Type:      "blocklistclear",
+		GuildID:   params.GuildID,
+		Kind:      kind,
+		ListType:  params.ListType,
+		ListTypes: listtype.Names(),
+	}
+	switch kind {
+	case kindCategory:
+		message.CategoryID = targetID
+		break
+	case kindRole:
+		message.RoleID = targetID
+		break
+	default:
+		message.ChannelID = targetID
+		break
+	}
+	if err := publishToRemraku(request.Context(), message); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+}
+
+func clearEntry(ctx context.Context, guildID string, kind string, targetID string, listType listtype.Type) (err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	tag, err := pool.Exec(ctx, "DELETE FROM blocklistentries WHERE guildID = $1 AND targetKind = $2 AND targetID = $3 AND listType = $4", guildID, kind, targetID, listType)
+	if err != nil {
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		err = errEntryNotFound
+	}
+	return
+
+}
+
+// clearBlocklistMessage tells remraku the target has no entry of its own on the list anymore.
+type clearBlocklistMessage struct {
+	Type       string          `json:"type"`
+	GuildID    string          `json:"guildID"`
+	Kind       string          `json:"kind"`
+	ChannelID  string          `json:"channelID,omitempty"`
+	CategoryID string          `json:"categoryID,omitempty"`
+	RoleID     string          `json:"roleID,omitempty"`
+	ListType   listtype.Type   `json:"listType"`
+	ListTypes  []listtype.Type `json:"listTypes"`
+}
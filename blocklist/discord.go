@@ -0,0 +1,64 @@
+package remblocklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Channel types Discord uses that the blocklist cares about.
+const (
+	channelTypeCategory = 4
+)
+
+type DiscordRole struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type DiscordChannel struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     int    `json:"type"`
+	ParentID string `json:"parent_id"`
+	Position int    `json:"position"`
+}
+
+func fetchGuildRoles(ctx context.Context, guildID string) (roles []DiscordRole, err error) {
+	err = discordGet(ctx, fmt.Sprintf("/guilds/%s/roles", guildID), &roles)
+	return
+}
+
+// fetchGuildChannels lists every channel and category of the guild, threads are not included.
+func fetchGuildChannels(ctx context.Context, guildID string) (channels []DiscordChannel, err error) {
+	err = discordGet(ctx, fmt.Sprintf("/guilds/%s/channels", guildID), &channels)
+	return
+}
+
+func discordGet(ctx context.Context, path string, v interface{}) (err error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, os.Getenv("DISCORD_BASE_URI")+path, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bot %s", os.Getenv("DISCORD_TOKEN")))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Discord responded with " + resp.Status)
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return
+
+}
@@ -0,0 +1,108 @@
+package remblocklist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+// Where a channel's effective state came from.
+const (
+	sourceChannel  = "channel"
+	sourceCategory = "category"
+	sourceDefault  = "default"
+)
+
+type EffectiveState struct {
+	State  bool   `json:"state"`
+	Source string `json:"source"`
+}
+
+// EffectiveChannel is what remraku does in a channel, with its category's entries already applied.
+type EffectiveChannel struct {
+	ChannelID string                           `json:"channelID"`
+	Name      string                           `json:"name"`
+	Type      int                              `json:"type"`
+	ParentID  string                           `json:"parentID,omitempty"`
+	Lists     map[listtype.Type]EffectiveState `json:"lists"`
+}
+
+// getEffectiveBlocklist answers GET requests with effective=true, once the caller has been checked.
+func getEffectiveBlocklist(writer http.ResponseWriter, request *http.Request, guildID string) {
+
+	entries, err := fetchBlocklist(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch blocklist: ", err)
+		return
+	}
+
+	channels, err := fetchGuildChannels(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch guild channels: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(resolveEffective(channels, entries))
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+// resolveEffective works out every list's state for each channel that isn't a category.
+// A channel's own entry wins over its category's, even when it unblocks the channel.
+func resolveEffective(channels []DiscordChannel, entries []BlocklistEntry) (effective []EffectiveChannel) {
+
+	type listKey struct {
+		targetID string
+		listType listtype.Type
+	}
+	channelStates := make(map[listKey]bool)
+	categoryStates := make(map[listKey]bool)
+	for _, entry := range entries {
+		switch entry.Kind {
+		case kindChannel:
+			channelStates[listKey{entry.ChannelID, entry.ListType}] = entry.State
+			break
+		case kindCategory:
+			categoryStates[listKey{entry.CategoryID, entry.ListType}] = entry.State
+			break
+		}
+	}
+
+	effective = make([]EffectiveChannel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.Type == channelTypeCategory {
+			continue
+		}
+		resolved := EffectiveChannel{
+			ChannelID: channel.ID,
+			Name:      channel.Name,
+			Type:      channel.Type,
+			ParentID:  channel.ParentID,
+			Lists:     make(map[listtype.Type]EffectiveState, len(listtype.All)),
+		}
+		for _, info := range listtype.All {
+			listType := info.Name
+			if state, ok := channelStates[listKey{channel.ID, listType}]; ok {
+				resolved.Lists[listType] = EffectiveState{State: state, Source: sourceChannel}
+			} else if state, ok := categoryStates[listKey{channel.ParentID, listType}]; ok && channel.ParentID != "" {
+				resolved.Lists[listType] = EffectiveState{State: state, Source: sourceCategory}
+			} else {
+				resolved.Lists[listType] = EffectiveState{State: false, Source: sourceDefault}
+			}
+		}
+		effective = append(effective, resolved)
+	}
+	return
+
+}
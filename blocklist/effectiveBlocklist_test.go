@@ -0,0 +1,66 @@
+package remblocklist
+
+import (
+	"testing"
+)
+
+func TestResolveEffective(t *testing.T) {
+
+	channels := []DiscordChannel{
+		{ID: "956209277926768600", Name: "Off topic", Type: channelTypeCategory},
+		{ID: "956209277926768650", Name: "memes", ParentID: "956209277926768600"},
+		{ID: "956209277926768651", Name: "bot-spam", ParentID: "956209277926768600"},
+		{ID: "956209277926768652", Name: "general"},
+	}
+	entries := []BlocklistEntry{
+		{Kind: kindCategory, CategoryID: "956209277926768600", ListType: "xpgain", State: true},
+		{Kind: kindChannel, ChannelID: "956209277926768650", ListType: "xpgain", State: false},
+	}
+
+	effective := resolveEffective(channels, entries)
+	if len(effective) != 3 {
+		t.Errorf("Expected the category itself to be left out, got %+v\n", effective)
+		return
+	}
+
+	want := map[string]EffectiveState{
+		"956209277926768650": {State: false, Source: sourceChannel},
+		"956209277926768651": {State: true, Source: sourceCategory},
+		"956209277926768652": {State: false, Source: sourceDefault},
+	}
+	for _, channel := range effective {
+		if got := channel.Lists["xpgain"]; got != want[channel.ChannelID] {
+			t.Errorf("Expected %+v for %s, got %+v\n", want[channel.ChannelID], channel.Name, got)
+		}
+	}
+
+}
+
+func TestValidateCategory(t *testing.T) {
+
+	channels := []DiscordChannel{
+		{ID: "956209277926768600", Type: channelTypeCategory},
+		{ID: "956209277926768650", ParentID: "956209277926768600"},
+	}
+
+	if err := validateCategory("956209277926768600", channels); err != nil {
+		t.Errorf("Expected category to be valid, got %s\n", err)
+	}
+	if err := validateCategory("956209277926768650", channels); err != errNotCategory {
+		t.Errorf("Expected %s, got %v\n", errNotCategory, err)
+	}
+	if err := validateCategory("956209277926768699", channels); err != errUnknownCategory {
+		t.Errorf("Expected %s, got %v\n", errUnknownCategory, err)
+	}
+
+	if err := validateChannel("956209277926768650", channels); err != nil {
+		t.Errorf("Expected channel to be valid, got %s\n", err)
+	}
+	if err := validateChannel("956209277926768600", channels); err != errIsCategory {
+		t.Errorf("Expected %s, got %v\n", errIsCategory, err)
+	}
+	if err := validateChannel("956209277926768699", channels); err != errUnknownChannel {
+		t.Errorf("Expected %s, got %v\n", errUnknownChannel, err)
+	}
+
+}
@@ -7,4 +7,7 @@ require (
 	cloud.google.com/go/pubsub v1.3.1
 	github.com/GoogleCloudPlatform/functions-framework-go v1.5.2
 	github.com/jackc/pgx/v4 v4.15.0
+	github.com/yayuyokitano/rem-next/listtype v0.0.0
 )
+
+replace github.com/yayuyokitano/rem-next/listtype => ../listtype
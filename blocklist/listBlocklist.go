@@ -0,0 +1,129 @@
+package remblocklist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+// BlocklistEntry is one target's state on one list, only the ID matching Kind is set.
+type BlocklistEntry struct {
+	Kind       string        `json:"kind"`
+	ChannelID  string        `json:"channelID,omitempty"`
+	CategoryID string        `json:"categoryID,omitempty"`
+	RoleID     string        `json:"roleID,omitempty"`
+	ListType   listtype.Type `json:"listType"`
+	State      bool          `json:"state"`
+}
+
+// target returns the ID of the channel, category or role the entry is about.
+func (e BlocklistEntry) target() (targetID string, err error) {
+	switch e.Kind {
+	case kindChannel, "":
+		targetID = e.ChannelID
+		break
+	case kindCategory:
+		targetID = e.CategoryID
+		break
+	case kindRole:
+		targetID = e.RoleID
+		break
+	default:
+		err = errors.New("Invalid target kind")
+		break
+	}
+	return
+}
+
+// setTarget sets the ID field matching the entry's kind.
+func (e *BlocklistEntry) setTarget(targetID string) {
+	switch e.Kind {
+	case kindCategory:
+		e.CategoryID = targetID
+		break
+	case kindRole:
+		e.RoleID = targetID
+		break
+	default:
+		e.ChannelID = targetID
+		break
+	}
+}
+
+func getBlocklist(writer http.ResponseWriter, request *http.Request) {
+
+	urlParams := request.URL.Query()
+	guildID := urlParams.Get("guildID")
+	userID := urlParams.Get("userID")
+	token, err := strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil || guildID == "" || userID == "" || token == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(guildID, userID, token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	if urlParams.Get("effective") == "true" {
+		getEffectiveBlocklist(writer, request, guildID)
+		return
+	}
+
+	entries, err := fetchBlocklist(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch blocklist: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(entries)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+// fetchBlocklist returns every entry of the guild, channels first, then categories and roles.
+func fetchBlocklist(ctx context.Context, guildID string) (entries []BlocklistEntry, err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	rows, err := pool.Query(ctx, `SELECT targetKind, targetID, listType, state FROM blocklistentries WHERE guildID = $1
+		ORDER BY CASE targetKind WHEN 'channel' THEN 0 WHEN 'category' THEN 1 ELSE 2 END, targetID, listType`, guildID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	entries = make([]BlocklistEntry, 0)
+	for rows.Next() {
+		var targetID string
+		var entry BlocklistEntry
+		err = rows.Scan(&entry.Kind, &targetID, &entry.ListType, &entry.State)
+		if err != nil {
+			return
+		}
+		entry.setTarget(targetID)
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	return
+
+}
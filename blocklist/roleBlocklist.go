@@ -0,0 +1,76 @@
+package remblocklist
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+var (
+	errUnknownRole  = errors.New("Role does not exist on the guild")
+	errEveryoneRole = errors.New("Blocking @everyone would stop the whole guild from gaining XP")
+)
+
+func postRoleBlocklist(writer http.ResponseWriter, request *http.Request, params Params) {
+
+	// Roles that were deleted since can still be taken off the blocklist.
+	if params.State {
+		roles, err := fetchGuildRoles(request.Context(), params.GuildID)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to fetch guild roles: ", err)
+			return
+		}
+		if err := validateRole(params.GuildID, params.RoleID, roles); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+	}
+
+	if err := pushToDB(params.GuildID, kindRole, params.RoleID, params.ListType, params.State, request); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	if err := publishToRemraku(request.Context(), roleBlocklistMessage{
+		Type:      "roleblocklist",
+		GuildID:   params.GuildID,
+		RoleID:    params.RoleID,
+		ListType:  params.ListType,
+		State:     params.State,
+		ListTypes: listtype.Names(),
+	}); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+}
+
+// validateRole makes sure the role belongs to the guild. Managed roles are allowed, blocking bots is a common use.
+func validateRole(guildID string, roleID string, roles []DiscordRole) (err error) {
+
+	if roleID == guildID {
+		return errEveryoneRole
+	}
+	for _, role := range roles {
+		if role.ID == roleID {
+			return
+		}
+	}
+	return errUnknownRole
+
+}
+
+type roleBlocklistMessage struct {
+	Type      string          `json:"type"`
+	GuildID   string          `json:"guildID"`
+	RoleID    string          `json:"roleID"`
+	ListType  listtype.Type   `json:"listType"`
+	State     bool            `json:"state"`
+	ListTypes []listtype.Type `json:"listTypes"`
+}
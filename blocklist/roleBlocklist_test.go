@@ -0,0 +1,44 @@
+package remblocklist
+
+import (
+	"testing"
+)
+
+func TestValidateRole(t *testing.T) {
+
+	guildID := "956209277926768640"
+	roles := []DiscordRole{
+		{ID: guildID, Name: "@everyone"},
+		{ID: "956209277926768700", Name: "Muted"},
+	}
+
+	if err := validateRole(guildID, "956209277926768700", roles); err != nil {
+		t.Errorf("Expected role to be valid, got %s\n", err)
+	}
+	if err := validateRole(guildID, "956209277926768799", roles); err != errUnknownRole {
+		t.Errorf("Expected %s, got %v\n", errUnknownRole, err)
+	}
+	if err := validateRole(guildID, guildID, roles); err != errEveryoneRole {
+		t.Errorf("Expected %s, got %v\n", errEveryoneRole, err)
+	}
+
+}
+
+func TestParamsTarget(t *testing.T) {
+
+	params := Params{ChannelID: "956209277926768650", RoleID: "956209277926768700"}
+	if targetID, err := params.target(); err != nil || targetID != params.ChannelID {
+		t.Errorf("Expected channels to be the default, got %s, %v\n", targetID, err)
+	}
+
+	params.Kind = kindRole
+	if targetID, err := params.target(); err != nil || targetID != params.RoleID {
+		t.Errorf("Expected role %s, got %s, %v\n", params.RoleID, targetID, err)
+	}
+
+	params.Kind = "user"
+	if _, err := params.target(); err == nil {
+		t.Errorf("Expected unknown kinds to be rejected\n")
+	}
+
+}
@@ -0,0 +1,117 @@
+// Package confirmation signs short-lived tokens for two-step destructive operations.
+//
+// The first request describes what an operation would do and hands out a token,
+// the operation only runs once the same caller sends that token back for the same
+// guild and operation before it expires. Tokens are signed with CONFIRMATION_SECRET,
+// so any function sharing the secret can check them without storing anything.
+package confirmation
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// TTL is how long a token stays valid after it is issued.
+const TTL = 5 * time.Minute
+
+var (
+	ErrInvalid = errors.New("Invalid confirmation token")
+	ErrExpired = errors.New("Confirmation token has expired")
+	ErrSecret  = errors.New("CONFIRMATION_SECRET is not set")
+)
+
+type claims struct {
+	GuildID   string `json:"g"`
+	CallerID  string `json:"c"`
+	Operation string `json:"o"`
+	Expires   int64  `json:"e"`
+}
+
+func secret() (key []byte, err error) {
+	key = []byte(os.Getenv("CONFIRMATION_SECRET"))
+	if len(key) == 0 {
+		err = ErrSecret
+	}
+	return
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token for operation on the guild that only callerID can redeem.
+func Issue(guildID string, callerID string, operation string) (token string, expires time.Time, err error) {
+	return issueAt(guildID, callerID, operation, time.Now())
+}
+
+func issueAt(guildID string, callerID string, operation string, now time.Time) (token string, expires time.Time, err error) {
+
+	key, err := secret()
+	if err != nil {
+		return
+	}
+
+	expires = now.Add(TTL).Truncate(time.Second)
+	raw, err := json.Marshal(claims{
+		GuildID:   guildID,
+		CallerID:  callerID,
+		Operation: operation,
+		Expires:   expires.Unix(),
+	})
+	if err != nil {
+		return
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	token = payload + "." + sign(key, payload)
+	return
+
+}
+
+// Verify checks that token was issued for exactly this guild, caller and operation and has not expired.
+func Verify(token string, guildID string, callerID string, operation string) (err error) {
+	return verifyAt(token, guildID, callerID, operation, time.Now())
+}
+
+func verifyAt(token string, guildID string, callerID string, operation string, now time.Time) (err error) {
+
+	key, err := secret()
+	if err != nil {
+		return
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(sign(key, parts[0]))) {
+		err = ErrInvalid
+		return
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		err = ErrInvalid
+		return
+	}
+	var c claims
+	if json.Unmarshal(raw, &c) != nil {
+		err = ErrInvalid
+		return
+	}
+
+	if c.GuildID != guildID || c.CallerID != callerID || c.Operation != operation {
+		err = ErrInvalid
+		return
+	}
+	if now.Unix() > c.Expires {
+		err = ErrExpired
+	}
+	return
+
+}
@@ -0,0 +1,63 @@
+package confirmation
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfirmation(t *testing.T) {
+
+	os.Setenv("CONFIRMATION_SECRET", "test secret")
+	defer os.Unsetenv("CONFIRMATION_SECRET")
+
+	now := time.Now()
+	token, expires, err := issueAt("719255152170762301", "196249128286552064", "reset", now)
+	if err != nil {
+		t.Errorf("Failed to issue token: %s\n", err)
+		return
+	}
+	if expires.Before(now.Add(TTL - time.Second)) {
+		t.Errorf("Expected token to last %s, expires %s\n", TTL, expires)
+	}
+
+	if err = verifyAt(token, "719255152170762301", "196249128286552064", "reset", now); err != nil {
+		t.Errorf("Expected token to be valid, got %s\n", err)
+	}
+
+	mismatched := [][3]string{
+		{"719255152170762302", "196249128286552064", "reset"},
+		{"719255152170762301", "196249128286552065", "reset"},
+		{"719255152170762301", "196249128286552064", "import"},
+	}
+	for _, m := range mismatched {
+		if err = verifyAt(token, m[0], m[1], m[2], now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Expected token to be rejected for %v, got %v\n", m, err)
+		}
+	}
+
+	if err = verifyAt(token, "719255152170762301", "196249128286552064", "reset", now.Add(TTL+time.Second)); !errors.Is(err, ErrExpired) {
+		t.Errorf("Expected expired token to be rejected, got %v\n", err)
+	}
+
+	tampered := "x" + token
+	if err = verifyAt(tampered, "719255152170762301", "196249128286552064", "reset", now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected tampered token to be rejected, got %v\n", err)
+	}
+
+	os.Setenv("CONFIRMATION_SECRET", "another secret")
+	if err = verifyAt(token, "719255152170762301", "196249128286552064", "reset", now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Expected token signed with another secret to be rejected, got %v\n", err)
+	}
+
+}
+
+func TestConfirmationNoSecret(t *testing.T) {
+
+	os.Unsetenv("CONFIRMATION_SECRET")
+	if _, _, err := Issue("719255152170762301", "196249128286552064", "reset"); !errors.Is(err, ErrSecret) {
+		t.Errorf("Expected missing secret to be an error, got %v\n", err)
+	}
+
+}
@@ -0,0 +1,3 @@
+module github.com/yayuyokitano/rem-next/confirmation
+
+go 1.16
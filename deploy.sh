@@ -10,7 +10,13 @@ while read p; do
     changeall=1
     break
   fi
-  changes[${p%%/*}]=1
+  dir=${p%%/*}
+  # shared libraries are vendored into the functions that use them, so redeploy everything
+  if [[ $dir != _* && -f $dir/go.mod ]] && ! grep -qs functions-framework-go "$dir/go.mod"; then
+    changeall=1
+    break
+  fi
+  changes[$dir]=1
 done < /workspace/git-diff.txt
 
 env=""
@@ -25,9 +31,26 @@ done < config.ini
 
 for d in */ ; do
   [[ $d == _* ]] && continue
+  grep -qs functions-framework-go "${d%/}/go.mod" || continue
   [[ ${changes[${d%/}]} != 1 && $changeall != 1 ]] && continue
   cd "${d%/}"
 
   gcloud functions deploy "${d%/}" --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-http --allow-unauthenticated --runtime go116
+  # modify-levels also holds the workers that run and sweep its import jobs
+  if [[ ${d%/} == modify-levels ]]; then
+    gcloud functions deploy import-worker --entry-point ImportWorker --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic importjobs --timeout 540s --runtime go116
+    # Cloud Scheduler publishes to importsweep to queue again import jobs whose worker died
+    gcloud functions deploy import-sweeper --entry-point ImportSweeper --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic importsweep --runtime go116
+  fi
+  # role-sync also holds the workers that run and sweep its sync jobs, record role grants and take back expired roles
+  if [[ ${d%/} == role-sync ]]; then
+    gcloud functions deploy role-sync-worker --entry-point RoleSyncWorker --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic rolesyncjobs --timeout 540s --runtime go116
+    # Cloud Scheduler publishes to rolesyncsweep to queue again sync jobs whose worker died
+    gcloud functions deploy role-sync-sweeper --entry-point RoleSyncSweeper --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic rolesyncsweep --runtime go116
+    # Cloud Scheduler publishes to roleexpiry to take back expired role rewards
+    gcloud functions deploy role-expiry-worker --entry-point RoleExpiryWorker --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic roleexpiry --timeout 540s --runtime go116
+    # remraku publishes to rolerewardgrants when it hands out reward roles on level up
+    gcloud functions deploy role-grant-worker --entry-point RoleGrantWorker --set-env-vars="${env%,}" --vpc-connector rem-connector --region=us-central1 --source . --trigger-topic rolerewardgrants --runtime go116
+  fi
   cd ../
 done
\ No newline at end of file
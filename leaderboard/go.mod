@@ -0,0 +1,11 @@
+module github.com/yayuyokitano/rem-next/leaderboard
+
+go 1.16
+
+require (
+	github.com/GoogleCloudPlatform/functions-framework-go v1.5.2
+	github.com/jackc/pgx/v4 v4.15.0
+	github.com/yayuyokitano/rem-next/levelcurve v0.0.0
+)
+
+replace github.com/yayuyokitano/rem-next/levelcurve => ../levelcurve
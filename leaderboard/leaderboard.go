@@ -0,0 +1,318 @@
+package remleaderboard
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 100
+)
+
+func init() {
+	functions.HTTP("leaderboard", leaderboard)
+}
+
+var pool *pgxpool.Pool
+
+func createPool() (err error) {
+	if pool == nil {
+		ctx := context.Background()
+
+		pool, err = pgxpool.Connect(ctx, os.Getenv("DATABASE_PRIVATE_URL"))
+	}
+	return
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func corsHandler(writer http.ResponseWriter, request *http.Request) {
+	allowed := strings.Split(os.Getenv("ALLOWED_ORIGINS"), "||")
+	origin := request.Header.Get("Origin")
+	if contains(allowed, origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+type LeaderboardParams struct {
+	GuildID string
+	UserID  string
+	Token   int64
+	Page    int
+	Limit   int
+	Cursor  *Cursor
+}
+
+// Cursor points at the last entry of the previous page.
+// The rank is carried along so keyset pages don't have to count every member above them.
+type Cursor struct {
+	Rank   int64
+	XP     int64
+	UserID string
+}
+
+type Entry struct {
+	Rank     int64  `json:"rank"`
+	UserID   string `json:"userID"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+	levelcurve.Progress
+}
+
+type Leaderboard struct {
+	Entries []Entry `json:"entries"`
+	// Pass as cursor to fetch the next page, empty on the last page.
+	NextCursor string `json:"nextCursor"`
+}
+
+func leaderboard(writer http.ResponseWriter, request *http.Request) {
+
+	corsHandler(writer, request)
+
+	params, err := parseParams(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+
+	err = createPool()
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to create pool: ", err)
+		return
+	}
+
+	public, curve, err := getGuildSettings(request.Context(), params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch guild settings: ", err)
+		return
+	}
+
+	if !public {
+		if params.UserID == "" || params.Token == 0 {
+			writer.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(writer, "Leaderboard is not public")
+			return
+		}
+		if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+			writer.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(writer, "Invalid permission: ", err)
+			return
+		}
+	}
+
+	board, err := getLeaderboard(request.Context(), params, curve)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch leaderboard: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(board)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func parseParams(request *http.Request) (params LeaderboardParams, err error) {
+
+	urlParams := request.URL.Query()
+
+	params = LeaderboardParams{
+		GuildID: urlParams.Get("guildID"),
+		UserID:  urlParams.Get("userID"),
+		Limit:   defaultLimit,
+	}
+
+	if params.GuildID == "" {
+		err = errors.New("Missing parameters")
+		return
+	}
+
+	if token := urlParams.Get("token"); token != "" {
+		params.Token, err = strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			err = errors.New("Invalid token")
+			return
+		}
+	}
+
+	if limit := urlParams.Get("limit"); limit != "" {
+		params.Limit, err = strconv.Atoi(limit)
+		if err != nil || params.Limit <= 0 || params.Limit > maxLimit {
+			err = fmt.Errorf("Limit must be between 1 and %d", maxLimit)
+			return
+		}
+	}
+
+	if page := urlParams.Get("page"); page != "" {
+		params.Page, err = strconv.Atoi(page)
+		if err != nil || params.Page < 0 {
+			err = errors.New("Invalid page")
+			return
+		}
+	}
+
+	if cursor := urlParams.Get("cursor"); cursor != "" {
+		if params.Page != 0 {
+			err = errors.New("Page and cursor cannot be combined")
+			return
+		}
+		var c Cursor
+		c, err = decodeCursor(cursor)
+		if err != nil {
+			return
+		}
+		params.Cursor = &c
+	}
+
+	return
+
+}
+
+func encodeCursor(c Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d:%s", c.Rank, c.XP, c.UserID)))
+}
+
+func decodeCursor(s string) (c Cursor, err error) {
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		err = errors.New("Invalid cursor")
+		return
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || parts[2] == "" {
+		err = errors.New("Invalid cursor")
+		return
+	}
+
+	c.Rank, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		err = errors.New("Invalid cursor")
+		return
+	}
+	c.XP, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		err = errors.New("Invalid cursor")
+		return
+	}
+	c.UserID = parts[2]
+	return
+
+}
+
+func confirmPermission(guildID string, callerID string, token int64) (err error) {
+
+	client := &http.Client{}
+	resp, err := client.Post(os.Getenv("GCP_BASE_URI")+"/confirm-permission", "application/json", strings.NewReader(fmt.Sprintf(`{"guildID":"%s","userID":"%s","token":%d}`, guildID, callerID, token)))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Invalid token or user, or insufficient guild permissions: " + string(rawBody))
+		return
+	}
+	return
+}
+
+func getGuildSettings(ctx context.Context, guildID string) (public bool, curve levelcurve.Curve, err error) {
+
+	var rawCurve []byte
+	err = pool.QueryRow(ctx, "SELECT publicLeaderboard, levelCurve FROM guildsettings WHERE guildID = $1", guildID).Scan(&public, &rawCurve)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+
+	curve, err = levelcurve.Parse(rawCurve)
+	return
+
+}
+
+func getLeaderboard(ctx context.Context, params LeaderboardParams, curve levelcurve.Curve) (board Leaderboard, err error) {
+
+	// Ties on xp are broken by user ID so that pages never overlap or skip members.
+	var rows pgx.Rows
+	var rank int64
+	if params.Cursor != nil {
+		rank = params.Cursor.Rank
+		rows, err = pool.Query(ctx, `SELECT userID, nickname, avatar, xp FROM guildxp
+			WHERE guildID = $1 AND (xp < $2 OR (xp = $2 AND userID > $3))
+			ORDER BY xp DESC, userID ASC LIMIT $4`, params.GuildID, params.Cursor.XP, params.Cursor.UserID, params.Limit+1)
+	} else {
+		rank = int64(params.Page) * int64(params.Limit)
+		rows, err = pool.Query(ctx, `SELECT userID, nickname, avatar, xp FROM guildxp
+			WHERE guildID = $1
+			ORDER BY xp DESC, userID ASC LIMIT $2 OFFSET $3`, params.GuildID, params.Limit+1, rank)
+	}
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	board.Entries = make([]Entry, 0, params.Limit)
+	for rows.Next() {
+		var entry Entry
+		var xp int64
+		err = rows.Scan(&entry.UserID, &entry.Nickname, &entry.Avatar, &xp)
+		if err != nil {
+			return
+		}
+		rank++
+		entry.Rank = rank
+		entry.Progress = levelcurve.ProgressToNext(curve, xp)
+		board.Entries = append(board.Entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	if len(board.Entries) > params.Limit {
+		board.Entries = board.Entries[:params.Limit]
+		last := board.Entries[params.Limit-1]
+		board.NextCursor = encodeCursor(Cursor{
+			Rank:   last.Rank,
+			XP:     last.XP,
+			UserID: last.UserID,
+		})
+	}
+
+	return
+
+}
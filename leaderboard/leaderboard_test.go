@@ -0,0 +1,113 @@
+package remleaderboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestLeaderboard(t *testing.T) {
+
+	query := fmt.Sprintf("/leaderboard?guildID=%s&userID=%s&token=%s&limit=2", os.Getenv("REM_TEST_GUILDID"), os.Getenv("REM_TEST_USERID"), os.Getenv("REM_TEST_TOKEN"))
+	firstPage, err := fetchPage(query)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i, entry := range firstPage.Entries {
+		if entry.Rank != int64(i+1) {
+			t.Errorf("Expected rank %d, got %d\n", i+1, entry.Rank)
+			return
+		}
+		if i > 0 && entry.XP > firstPage.Entries[i-1].XP {
+			t.Errorf("Entries are not ordered by xp: %d before %d\n", firstPage.Entries[i-1].XP, entry.XP)
+			return
+		}
+	}
+
+	if firstPage.NextCursor == "" {
+		return
+	}
+
+	cursorPage, err := fetchPage(query + "&cursor=" + firstPage.NextCursor)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	offsetPage, err := fetchPage(query + "&page=1")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(cursorPage.Entries) != len(offsetPage.Entries) {
+		t.Errorf("Cursor and page returned different sizes: %d and %d\n", len(cursorPage.Entries), len(offsetPage.Entries))
+		return
+	}
+
+	for i := range cursorPage.Entries {
+		if cursorPage.Entries[i] != offsetPage.Entries[i] {
+			t.Errorf("Cursor and page disagree at %d: %v and %v\n", i, cursorPage.Entries[i], offsetPage.Entries[i])
+			return
+		}
+	}
+
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", query+"&page=1&cursor="+firstPage.NextCursor, nil)
+
+	leaderboard(writer, request)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusBadRequest, writer.Code, writer.Body)
+		return
+	}
+
+}
+
+func fetchPage(query string) (board Leaderboard, err error) {
+
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", query, nil)
+
+	leaderboard(writer, request)
+
+	if writer.Code != http.StatusOK {
+		err = fmt.Errorf("Expected %d, got %d:%s", http.StatusOK, writer.Code, writer.Body)
+		return
+	}
+
+	err = json.NewDecoder(writer.Body).Decode(&board)
+	return
+
+}
+
+func TestCursor(t *testing.T) {
+
+	cursor := Cursor{
+		Rank:   101,
+		XP:     123456,
+		UserID: "196249128286552064",
+	}
+
+	decoded, err := decodeCursor(encodeCursor(cursor))
+	if err != nil {
+		t.Errorf("Failed to decode cursor: %s\n", err)
+		return
+	}
+	if decoded != cursor {
+		t.Errorf("Expected %v, got %v\n", cursor, decoded)
+		return
+	}
+
+	for _, invalid := range []string{"", "not base64!", "MToy"} {
+		if _, err := decodeCursor(invalid); err == nil {
+			t.Errorf("Expected error for cursor %q\n", invalid)
+		}
+	}
+
+}
@@ -0,0 +1,3 @@
+module github.com/yayuyokitano/rem-next/levelcurve
+
+go 1.16
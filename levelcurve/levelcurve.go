@@ -0,0 +1,213 @@
+// Package levelcurve converts between the raw xp stored in guildXP and levels.
+//
+// Every curve is described by the total xp needed to reach each level, which
+// keeps lookups cheap for closed-form curves and explicit tables alike.
+package levelcurve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// MaxLevel bounds every curve so that lookups always terminate.
+const MaxLevel = 10000
+
+const (
+	TypeMEE6        = "mee6"
+	TypeLinear      = "linear"
+	TypeExponential = "exponential"
+	TypeTable       = "table"
+)
+
+type Curve interface {
+	// TotalXP returns the xp needed to reach level from zero, saturating at math.MaxInt64.
+	// It must be 0 for level 0 and never decrease as level grows.
+	TotalXP(level int) int64
+}
+
+// Progress describes how far xp is into its current level.
+type Progress struct {
+	Level int   `json:"level"`
+	XP    int64 `json:"xp"`
+	// Current is the xp earned since reaching Level.
+	Current int64 `json:"current"`
+	// Needed is the xp between Level and the next level, 0 at MaxLevel.
+	Needed int64 `json:"needed"`
+}
+
+func XPForLevel(c Curve, level int) int64 {
+	if level <= 0 {
+		return 0
+	}
+	if level > MaxLevel {
+		level = MaxLevel
+	}
+	return c.TotalXP(level)
+}
+
+func LevelForXP(c Curve, xp int64) int {
+	if xp <= 0 {
+		return 0
+	}
+	// First level that xp is not enough for, minus one.
+	return sort.Search(MaxLevel+1, func(level int) bool {
+		return c.TotalXP(level) > xp
+	}) - 1
+}
+
+func ProgressToNext(c Curve, xp int64) (p Progress) {
+	p.XP = xp
+	p.Level = LevelForXP(c, xp)
+	start := XPForLevel(c, p.Level)
+	if xp > start {
+		p.Current = xp - start
+	}
+	if p.Level < MaxLevel {
+		p.Needed = XPForLevel(c, p.Level+1) - start
+	}
+	return
+}
+
+// MEE6 matches MEE6's leaderboard, going from level n to n+1 takes 5n² + 50n + 100 xp.
+var MEE6 Curve = mee6{}
+
+type mee6 struct{}
+
+func (mee6) TotalXP(level int) int64 {
+	n := int64(level)
+	// Sum of 5k² + 50k + 100 for k in [0, n).
+	return 5*(n-1)*n*(2*n-1)/6 + 25*n*(n-1) + 100*n
+}
+
+// Linear makes every level cost the same amount of xp.
+type Linear struct {
+	XPPerLevel int64
+}
+
+func (l Linear) TotalXP(level int) int64 {
+	if int64(level) > math.MaxInt64/l.XPPerLevel {
+		return math.MaxInt64
+	}
+	return int64(level) * l.XPPerLevel
+}
+
+// Exponential makes level n to n+1 cost Base * Factor^n xp.
+type Exponential struct {
+	Base   int64
+	Factor float64
+}
+
+func (e Exponential) TotalXP(level int) int64 {
+	total := float64(e.Base) * (math.Pow(e.Factor, float64(level)) - 1) / (e.Factor - 1)
+	if total >= math.MaxInt64 || math.IsInf(total, 1) {
+		return math.MaxInt64
+	}
+	return int64(total)
+}
+
+// Table lists the total xp for levels 1, 2, 3 and so on.
+// Levels past the end of the table keep costing the same as the last listed level did.
+type Table struct {
+	Thresholds []int64
+}
+
+func (t Table) TotalXP(level int) int64 {
+	if level <= 0 {
+		return 0
+	}
+	if level <= len(t.Thresholds) {
+		return t.Thresholds[level-1]
+	}
+
+	last := t.Thresholds[len(t.Thresholds)-1]
+	step := last
+	if len(t.Thresholds) > 1 {
+		step = last - t.Thresholds[len(t.Thresholds)-2]
+	}
+
+	extra := int64(level - len(t.Thresholds))
+	if extra > (math.MaxInt64-last)/step {
+		return math.MaxInt64
+	}
+	return last + extra*step
+}
+
+// Config is how a guild's curve is stored in guildsettings.levelCurve and sent to the dashboard.
+type Config struct {
+	Type       string  `json:"type"`
+	XPPerLevel int64   `json:"xpPerLevel,omitempty"`
+	Base       int64   `json:"base,omitempty"`
+	Factor     float64 `json:"factor,omitempty"`
+	Thresholds []int64 `json:"thresholds,omitempty"`
+}
+
+var DefaultConfig = Config{Type: TypeMEE6}
+
+// Curve validates the config and builds the curve it describes.
+func (c Config) Curve() (curve Curve, err error) {
+
+	switch c.Type {
+	case TypeMEE6:
+		curve = MEE6
+		break
+	case TypeLinear:
+		if c.XPPerLevel <= 0 {
+			err = errors.New("Linear curves need a positive xpPerLevel")
+			return
+		}
+		curve = Linear{XPPerLevel: c.XPPerLevel}
+		break
+	case TypeExponential:
+		if c.Base <= 0 {
+			err = errors.New("Exponential curves need a positive base")
+			return
+		}
+		if c.Factor <= 1 || c.Factor > 10 {
+			err = errors.New("Exponential curves need a factor above 1 and at most 10")
+			return
+		}
+		curve = Exponential{Base: c.Base, Factor: c.Factor}
+		break
+	case TypeTable:
+		if len(c.Thresholds) == 0 || len(c.Thresholds) > MaxLevel {
+			err = fmt.Errorf("Table curves need between 1 and %d thresholds", MaxLevel)
+			return
+		}
+		previous := int64(0)
+		for i, threshold := range c.Thresholds {
+			if threshold <= previous {
+				err = fmt.Errorf("Threshold for level %d must be higher than the one before it", i+1)
+				return
+			}
+			previous = threshold
+		}
+		curve = Table{Thresholds: c.Thresholds}
+		break
+	default:
+		err = fmt.Errorf("Unknown curve type %q", c.Type)
+		break
+	}
+	return
+
+}
+
+// Parse reads a stored Config, falling back to the default curve when nothing is stored.
+func Parse(raw []byte) (curve Curve, err error) {
+
+	if len(raw) == 0 {
+		return DefaultConfig.Curve()
+	}
+
+	var config Config
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		return
+	}
+
+	curve, err = config.Curve()
+	return
+
+}
@@ -0,0 +1,129 @@
+package levelcurve
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMEE6(t *testing.T) {
+
+	// Totals as shown on MEE6's own leaderboard.
+	expected := map[int]int64{
+		0:  0,
+		1:  100,
+		2:  255,
+		3:  475,
+		5:  1150,
+		10: 4675,
+	}
+
+	for level, xp := range expected {
+		if got := XPForLevel(MEE6, level); got != xp {
+			t.Errorf("Level %d: expected %d xp, got %d\n", level, xp, got)
+		}
+		if got := LevelForXP(MEE6, xp); got != level {
+			t.Errorf("%d xp: expected level %d, got %d\n", xp, level, got)
+		}
+	}
+
+}
+
+func TestRoundTrip(t *testing.T) {
+
+	curves := map[string]Curve{
+		"mee6":        MEE6,
+		"linear":      Linear{XPPerLevel: 1000},
+		"exponential": Exponential{Base: 100, Factor: 1.1},
+		"table":       Table{Thresholds: []int64{10, 50, 100}},
+	}
+
+	for name, curve := range curves {
+		for level := 1; level <= 200; level++ {
+			xp := XPForLevel(curve, level)
+			if got := LevelForXP(curve, xp); got != level {
+				t.Errorf("%s: %d xp should be level %d, got %d\n", name, xp, level, got)
+				break
+			}
+			if got := LevelForXP(curve, xp-1); got != level-1 {
+				t.Errorf("%s: %d xp should be level %d, got %d\n", name, xp-1, level-1, got)
+				break
+			}
+		}
+	}
+
+}
+
+func TestTableExtension(t *testing.T) {
+
+	table := Table{Thresholds: []int64{10, 50, 100}}
+	if got := XPForLevel(table, 5); got != 200 {
+		t.Errorf("Expected 200, got %d\n", got)
+	}
+
+	single := Table{Thresholds: []int64{10}}
+	if got := XPForLevel(single, 4); got != 40 {
+		t.Errorf("Expected 40, got %d\n", got)
+	}
+
+}
+
+func TestSaturation(t *testing.T) {
+
+	curve := Exponential{Base: 1000, Factor: 10}
+	if got := XPForLevel(curve, MaxLevel); got != math.MaxInt64 {
+		t.Errorf("Expected saturation, got %d\n", got)
+	}
+	if got := LevelForXP(curve, math.MaxInt64); got > MaxLevel {
+		t.Errorf("Level %d is above MaxLevel\n", got)
+	}
+	if got := LevelForXP(MEE6, -50); got != 0 {
+		t.Errorf("Expected level 0 for negative xp, got %d\n", got)
+	}
+
+}
+
+func TestProgressToNext(t *testing.T) {
+
+	p := ProgressToNext(MEE6, 300)
+	if p.Level != 2 || p.Current != 45 || p.Needed != 220 {
+		t.Errorf("Expected level 2 with 45/220, got %v\n", p)
+	}
+
+	p = ProgressToNext(MEE6, 0)
+	if p.Level != 0 || p.Current != 0 || p.Needed != 100 {
+		t.Errorf("Expected level 0 with 0/100, got %v\n", p)
+	}
+
+}
+
+func TestConfig(t *testing.T) {
+
+	curve, err := Parse(nil)
+	if err != nil || curve != MEE6 {
+		t.Errorf("Expected default MEE6 curve, got %v, %v\n", curve, err)
+	}
+
+	curve, err = Parse([]byte(`{"type":"linear","xpPerLevel":500}`))
+	if err != nil {
+		t.Errorf("Failed to parse linear curve: %s\n", err)
+	} else if got := XPForLevel(curve, 3); got != 1500 {
+		t.Errorf("Expected 1500, got %d\n", got)
+	}
+
+	invalid := []Config{
+		{Type: "quadratic"},
+		{Type: TypeLinear},
+		{Type: TypeExponential, Base: 100, Factor: 1},
+		{Type: TypeExponential, Factor: 2},
+		{Type: TypeTable},
+		{Type: TypeTable, Thresholds: []int64{100, 100}},
+		{Type: TypeTable, Thresholds: []int64{0, 100}},
+	}
+
+	for _, config := range invalid {
+		if _, err := config.Curve(); err == nil {
+			t.Errorf("Expected error for %v\n", config)
+		}
+	}
+
+}
@@ -0,0 +1,54 @@
+// Package listtype is the registry of lists the blocklist can put a channel, category or role on.
+//
+// Every function that tells remraku about blocklist changes sends the names along,
+// so the bot can tell a list type it doesn't know yet from a bad one.
+package listtype
+
+import (
+	"errors"
+)
+
+// Type is something a channel, category or role can be blocked from.
+type Type string
+
+const (
+	XPGain Type = "xpgain"
+	// LevelUp suppresses level up announcements.
+	LevelUp  Type = "levelup"
+	Commands Type = "commands"
+	RankCard Type = "rankcard"
+)
+
+type Info struct {
+	Name        Type   `json:"name"`
+	Description string `json:"description"`
+}
+
+// All is every list type a target can be put on, in the order they are listed.
+// The check on blocklistentries.listType has to be kept in line with it.
+var All = []Info{
+	{XPGain, "No XP is gained"},
+	{LevelUp, "Level up announcements are not sent"},
+	{Commands, "Commands cannot be used"},
+	{RankCard, "Rank cards are not shown"},
+}
+
+var ErrInvalid = errors.New("Invalid list type")
+
+func Valid(listType Type) bool {
+	for _, info := range All {
+		if info.Name == listType {
+			return true
+		}
+	}
+	return false
+}
+
+// Names lists the name of every list type, in the order they are listed.
+func Names() (names []Type) {
+	names = make([]Type, 0, len(All))
+	for _, info := range All {
+		names = append(names, info.Name)
+	}
+	return
+}
@@ -0,0 +1,23 @@
+package listtype
+
+import (
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+
+	for _, info := range All {
+		if !Valid(info.Name) {
+			t.Errorf("Expected %s to be valid\n", info.Name)
+		}
+	}
+	if Valid("xpgai") || Valid("") {
+		t.Errorf("Expected unknown list types to be invalid\n")
+	}
+
+	names := Names()
+	if len(names) != len(All) || names[0] != XPGain {
+		t.Errorf("Expected names in registry order, got %v\n", names)
+	}
+
+}
@@ -0,0 +1,112 @@
+package remmodifylevels
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/yayuyokitano/rem-next/confirmation"
+)
+
+// Impact is what a destructive operation would wipe or overwrite.
+type Impact struct {
+	Users       int64 `json:"users"`
+	RoleRewards int64 `json:"roleRewards"`
+}
+
+// ConfirmationRequired is sent instead of running a destructive operation,
+// the operation runs once confirmationToken is sent back with the same request.
+type ConfirmationRequired struct {
+	Operation         string    `json:"operation"`
+	Impact            Impact    `json:"impact"`
+	ConfirmationToken string    `json:"confirmationToken"`
+	ExpiresAt         time.Time `json:"expiresAt"`
+}
+
+// confirmationOperation returns what a confirmation token has to be bound to for params,
+// or false if the operation runs without one.
+func confirmationOperation(params LevelParams) (operation string, needed bool) {
+
+	switch params.Operation {
+	case "reset":
+		return params.Operation, true
+	case "import":
+		// Merging keeps existing levels, and dry runs do not write anything.
+		return params.Operation + ":" + importDigest(params), params.Merge == mergeReplace && !params.DryRun
+	case "restore":
+		return params.Operation + ":" + strconv.FormatInt(params.SnapshotID, 10), true
+	}
+	return "", false
+
+}
+
+// importDigest identifies what an import reads and how it merges it,
+// so confirming one import doesn't let a different file or source replace the guild.
+func importDigest(params LevelParams) string {
+
+	hash := sha256.New()
+	for _, part := range []string{params.Source, params.Merge, params.Format, params.APIKey, params.Data} {
+		hash.Write([]byte(strconv.Itoa(len(part)) + ":" + part))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+
+}
+
+// requireConfirmation writes the response and returns false unless the operation has been confirmed.
+func requireConfirmation(writer http.ResponseWriter, request *http.Request, params LevelParams) (confirmed bool) {
+
+	operation, needed := confirmationOperation(params)
+	if !needed {
+		return true
+	}
+
+	if params.ConfirmationToken != "" {
+		err := confirmation.Verify(params.ConfirmationToken, params.GuildID, params.CallerID, operation)
+		if errors.Is(err, confirmation.ErrInvalid) || errors.Is(err, confirmation.ErrExpired) {
+			writer.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(writer, "Failed to confirm operation: ", err)
+			return false
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to confirm operation: ", err)
+			return false
+		}
+		return true
+	}
+
+	impact, err := guildImpact(request.Context(), params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to get impact: ", err)
+		return false
+	}
+	token, expires, err := confirmation.Issue(params.GuildID, params.CallerID, operation)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to issue confirmation token: ", err)
+		return false
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(ConfirmationRequired{
+		Operation:         params.Operation,
+		Impact:            impact,
+		ConfirmationToken: token,
+		ExpiresAt:         expires,
+	})
+	return false
+
+}
+
+// guildImpact counts the rows reset, replacing imports and restores all swap out.
+func guildImpact(ctx context.Context, guildID string) (impact Impact, err error) {
+	err = pool.QueryRow(ctx, "SELECT (SELECT COUNT(*) FROM guildxp WHERE guildID = $1), (SELECT COUNT(*) FROM rolerewards WHERE guildID = $1)", guildID).Scan(&impact.Users, &impact.RoleRewards)
+	return
+}
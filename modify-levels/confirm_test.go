@@ -0,0 +1,48 @@
+package remmodifylevels
+
+import (
+	"testing"
+)
+
+func TestConfirmationOperation(t *testing.T) {
+
+	needed := map[string]LevelParams{
+		"reset":     {Operation: "reset"},
+		"restore:4": {Operation: "restore", SnapshotID: 4},
+	}
+	for expected, params := range needed {
+		operation, ok := confirmationOperation(params)
+		if !ok || operation != expected {
+			t.Errorf("Expected %+v to need confirmation as %q, got %q %v\n", params, expected, operation, ok)
+		}
+	}
+
+	// The same import is bound to the same operation, a different file or source is not.
+	file := LevelParams{Operation: "import", Source: "file", Format: "csv", Data: "userID,xp\n196249128286552064,10\n"}
+	operation, ok := confirmationOperation(file)
+	if again, _ := confirmationOperation(file); !ok || operation != again {
+		t.Errorf("Expected the same import to need the same confirmation, got %q and %q\n", operation, again)
+	}
+	for _, other := range []LevelParams{
+		{Operation: "import", Source: "file", Format: "csv", Data: "userID,xp\n196249128286552064,20\n"},
+		{Operation: "import", Source: "file", Format: "json", Data: file.Data},
+		{Operation: "import", Source: "mee6"},
+	} {
+		if otherOperation, _ := confirmationOperation(other); otherOperation == operation {
+			t.Errorf("Expected %+v to need its own confirmation\n", other)
+		}
+	}
+
+	notNeeded := []LevelParams{
+		{Operation: "import", Source: "mee6", Merge: mergeKeepHigher},
+		{Operation: "import", Source: "file", DryRun: true},
+		{Operation: "set", UserID: "196249128286552064"},
+		{Operation: "listSnapshots"},
+	}
+	for _, params := range notNeeded {
+		if _, ok := confirmationOperation(params); ok {
+			t.Errorf("Expected %+v to run without confirmation\n", params)
+		}
+	}
+
+}
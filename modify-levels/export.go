@@ -0,0 +1,227 @@
+package remmodifylevels
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Rows are flushed to the client in batches so the response never has to fit in memory.
+const exportFlushEvery = 1000
+
+type exportTable struct {
+	Name    string
+	Columns []string
+	Query   string
+}
+
+// Column order is part of the export format, only ever append to it.
+var exportTables = []exportTable{
+	{
+		Name:    "users",
+		Columns: []string{"userID", "nickname", "avatar", "xp"},
+		Query:   "SELECT userID, nickname, avatar, xp FROM guildxp WHERE guildID = $1 ORDER BY xp DESC, userID",
+	},
+	{
+		Name:    "roleRewards",
+		Columns: []string{"roleID", "level", "color", "persistent", "expiresAfter", "topN", "topWindowDays"},
+		Query:   "SELECT roleID, level, color, persistent, expiresAfter, topN, topWindowDays FROM rolerewards WHERE guildID = $1 ORDER BY level, roleID",
+	},
+}
+
+// rowSource is the part of pgx.Rows the exporters need.
+type rowSource interface {
+	Next() bool
+	Values() ([]interface{}, error)
+	Err() error
+}
+
+func findExportTable(name string) (table exportTable, err error) {
+	for _, t := range exportTables {
+		if t.Name == name {
+			table = t
+			return
+		}
+	}
+	err = errors.New("Invalid dataset")
+	return
+}
+
+func validateExport(params LevelParams) (err error) {
+	switch params.Format {
+	case "json":
+		break
+	case "csv":
+		// CSV has no way to hold two tables, so pick one per export.
+		_, err = findExportTable(params.Dataset)
+		break
+	default:
+		err = errors.New("Invalid format")
+		break
+	}
+	return
+}
+
+// exportLevels streams the export, started tells whether anything was written before err.
+// Every table is read from one snapshot, so the users and role rewards always match up.
+func exportLevels(ctx context.Context, writer http.ResponseWriter, params LevelParams) (started bool, err error) {
+
+	flush := func() {}
+	if flusher, ok := writer.(http.Flusher); ok {
+		flush = flusher.Flush
+	}
+
+	tables := exportTables
+	if params.Format == "csv" {
+		table, _ := findExportTable(params.Dataset)
+		tables = []exportTable{table}
+	}
+	guildID, err := json.Marshal(params.GuildID)
+	if err != nil {
+		return
+	}
+
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// The first query runs before any headers are sent, so a failure there still gets an error status.
+	rows, err := tx.Query(ctx, tables[0].Query, params.GuildID)
+	if err != nil {
+		return
+	}
+	// rows is replaced for every table, so the deferred close has to look it up when it runs.
+	defer func() { rows.Close() }()
+	started = true
+
+	if params.Format == "csv" {
+		writer.Header().Set("Content-Type", "text/csv")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, params.GuildID, tables[0].Name))
+		err = writeCSV(writer, flush, tables[0], rows)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, params.GuildID))
+	fmt.Fprintf(writer, `{"guildID":%s`, guildID)
+
+	for i, table := range tables {
+		// A connection only reads one result at a time, so the previous table is closed first.
+		if i > 0 {
+			rows.Close()
+			rows, err = tx.Query(ctx, table.Query, params.GuildID)
+			if err != nil {
+				return
+			}
+		}
+		fmt.Fprintf(writer, `,"%s":`, table.Name)
+		err = writeJSONArray(writer, flush, table, rows)
+		if err != nil {
+			return
+		}
+	}
+
+	fmt.Fprint(writer, "}")
+	return
+
+}
+
+func writeCSV(writer io.Writer, flush func(), table exportTable, rows rowSource) (err error) {
+
+	w := csv.NewWriter(writer)
+	err = w.Write(table.Columns)
+	if err != nil {
+		return
+	}
+
+	record := make([]string, len(table.Columns))
+	count := 0
+	for rows.Next() {
+		var values []interface{}
+		values, err = rows.Values()
+		if err != nil {
+			return
+		}
+		for i, v := range values {
+			// NULL columns are left empty rather than printed as <nil>.
+			record[i] = ""
+			if v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		err = w.Write(record)
+		if err != nil {
+			return
+		}
+
+		count++
+		if count%exportFlushEvery == 0 {
+			w.Flush()
+			flush()
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	w.Flush()
+	flush()
+	err = w.Error()
+	return
+
+}
+
+// writeJSONArray writes each row as an object with keys in column order, which map marshalling can't guarantee.
+func writeJSONArray(writer io.Writer, flush func(), table exportTable, rows rowSource) (err error) {
+
+	fmt.Fprint(writer, "[")
+
+	count := 0
+	for rows.Next() {
+		var values []interface{}
+		values, err = rows.Values()
+		if err != nil {
+			return
+		}
+
+		if count > 0 {
+			fmt.Fprint(writer, ",")
+		}
+		fmt.Fprint(writer, "{")
+		for i, v := range values {
+			var raw []byte
+			raw, err = json.Marshal(v)
+			if err != nil {
+				return
+			}
+			if i > 0 {
+				fmt.Fprint(writer, ",")
+			}
+			fmt.Fprintf(writer, `"%s":%s`, table.Columns[i], raw)
+		}
+		fmt.Fprint(writer, "}")
+
+		count++
+		if count%exportFlushEvery == 0 {
+			flush()
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	fmt.Fprint(writer, "]")
+	flush()
+	return
+
+}
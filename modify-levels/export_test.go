@@ -0,0 +1,95 @@
+package remmodifylevels
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type fakeRows struct {
+	rows [][]interface{}
+	cur  int
+}
+
+func (f *fakeRows) Next() bool {
+	f.cur++
+	return f.cur <= len(f.rows)
+}
+
+func (f *fakeRows) Values() ([]interface{}, error) {
+	return f.rows[f.cur-1], nil
+}
+
+func (f *fakeRows) Err() error {
+	return nil
+}
+
+func TestExport(t *testing.T) {
+
+	users, err := findExportTable("users")
+	if err != nil {
+		t.Errorf("Failed to find users table: %s\n", err)
+		return
+	}
+
+	rows := [][]interface{}{
+		{"196249128286552064", "Kitano", "a_1234", int64(5000)},
+		{"267794154459889664", "Comma, \"quoted\"", "", int64(20)},
+	}
+
+	var buf bytes.Buffer
+	err = writeCSV(&buf, func() {}, users, &fakeRows{rows: rows})
+	if err != nil {
+		t.Errorf("Failed to write CSV: %s\n", err)
+		return
+	}
+
+	expectedCSV := "userID,nickname,avatar,xp\n" +
+		"196249128286552064,Kitano,a_1234,5000\n" +
+		"267794154459889664,\"Comma, \"\"quoted\"\"\",,20\n"
+	if buf.String() != expectedCSV {
+		t.Errorf("Expected %q, got %q\n", expectedCSV, buf.String())
+	}
+
+	buf.Reset()
+	err = writeJSONArray(&buf, func() {}, users, &fakeRows{rows: rows})
+	if err != nil {
+		t.Errorf("Failed to write JSON: %s\n", err)
+		return
+	}
+
+	expectedJSON := `[{"userID":"196249128286552064","nickname":"Kitano","avatar":"a_1234","xp":5000},` +
+		`{"userID":"267794154459889664","nickname":"Comma, \"quoted\"","avatar":"","xp":20}]`
+	if buf.String() != expectedJSON {
+		t.Errorf("Expected %s, got %s\n", expectedJSON, buf.String())
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Errorf("Exported JSON is not valid\n")
+	}
+
+	roleRewards, err := findExportTable("roleRewards")
+	if err != nil {
+		t.Errorf("Failed to find role rewards table: %s\n", err)
+		return
+	}
+	buf.Reset()
+	err = writeCSV(&buf, func() {}, roleRewards, &fakeRows{rows: [][]interface{}{{"806249128286552064", int32(5), int32(0), false, nil, int32(10), nil}}})
+	expectedCSV = "roleID,level,color,persistent,expiresAfter,topN,topWindowDays\n806249128286552064,5,0,false,,10,\n"
+	if err != nil || buf.String() != expectedCSV {
+		t.Errorf("Expected %q, got %q, %v\n", expectedCSV, buf.String(), err)
+	}
+
+	buf.Reset()
+	err = writeJSONArray(&buf, func() {}, users, &fakeRows{})
+	if err != nil || buf.String() != "[]" {
+		t.Errorf("Expected empty array, got %s, %v\n", buf.String(), err)
+	}
+
+	if err := validateExport(LevelParams{Format: "csv"}); err == nil {
+		t.Errorf("Expected CSV export without dataset to be invalid\n")
+	}
+	if err := validateExport(LevelParams{Format: "xml"}); err == nil {
+		t.Errorf("Expected xml export to be invalid\n")
+	}
+
+}
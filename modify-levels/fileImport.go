@@ -0,0 +1,317 @@
+package remmodifylevels
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+const (
+	// maxImportXP is far above what any bot hands out, it only catches garbage values.
+	maxImportXP = 1000000000000
+	// Only the first errors are reported, a file that is wrong everywhere is usually the wrong file.
+	maxImportErrors = 100
+	// The expiry limits are the ones rolereward enforces when rewards are set directly.
+	maxExpiresAfter  = 366 * 24 * 60 * 60
+	maxTopN          = 1000
+	maxTopWindowDays = 366
+)
+
+// importFile uses the same shape as the json export, so exports can be imported again as-is.
+type importFile struct {
+	Users       []fileUser       `json:"users"`
+	RoleRewards []fileRoleReward `json:"roleRewards"`
+}
+
+type fileUser struct {
+	UserID   string `json:"userID"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+	XP       int64  `json:"xp"`
+	row      int
+}
+
+type fileRoleReward struct {
+	RoleID        string `json:"roleID"`
+	Level         int    `json:"level"`
+	Color         int    `json:"color"`
+	Persistent    bool   `json:"persistent"`
+	ExpiresAfter  *int   `json:"expiresAfter"`
+	TopN          *int   `json:"topN"`
+	TopWindowDays *int   `json:"topWindowDays"`
+	row           int
+}
+
+func (r fileRoleReward) imported() importedRoleReward {
+	return importedRoleReward{
+		RoleID:        r.RoleID,
+		Level:         r.Level,
+		Color:         r.Color,
+		Persistent:    r.Persistent,
+		ExpiresAfter:  r.ExpiresAfter,
+		TopN:          r.TopN,
+		TopWindowDays: r.TopWindowDays,
+	}
+}
+
+type RowError struct {
+	Dataset string `json:"dataset"`
+	// Row is the line number for csv files and the 1-based array index for json files, 0 for problems with the whole file.
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// ImportFileError lists every problem found in an uploaded file.
+type ImportFileError struct {
+	Errors []RowError `json:"errors"`
+	// Truncated is set when there were more errors than are listed.
+	Truncated bool `json:"truncated"`
+}
+
+func (e *ImportFileError) Error() string {
+	return fmt.Sprintf("Import file has %d invalid rows", len(e.Errors))
+}
+
+func (e *ImportFileError) add(dataset string, row int, message string) {
+	if len(e.Errors) >= maxImportErrors {
+		e.Truncated = true
+		return
+	}
+	e.Errors = append(e.Errors, RowError{
+		Dataset: dataset,
+		Row:     row,
+		Message: message,
+	})
+}
+
+func parseImportFile(format string, data string) (file importFile, err error) {
+
+	fileErr := &ImportFileError{}
+
+	switch format {
+	case "json":
+		err = json.Unmarshal([]byte(data), &file)
+		if err != nil {
+			fileErr.add("file", 0, err.Error())
+			err = fileErr
+			return
+		}
+		for i := range file.Users {
+			file.Users[i].row = i + 1
+		}
+		for i := range file.RoleRewards {
+			file.RoleRewards[i].row = i + 1
+		}
+		break
+	case "csv":
+		// CSV files only hold users, role rewards need the json format.
+		file.Users, err = parseUserCSV(data, fileErr)
+		if err != nil {
+			return
+		}
+		break
+	default:
+		err = errors.New("Invalid format")
+		return
+	}
+
+	if len(file.Users) == 0 && len(file.RoleRewards) == 0 && len(fileErr.Errors) == 0 {
+		fileErr.add("file", 0, "Import file is empty")
+		err = fileErr
+		return
+	}
+
+	file.validate(fileErr)
+	if len(fileErr.Errors) > 0 {
+		err = fileErr
+	}
+	return
+
+}
+
+// parseUserCSV reads a csv file with a header row, columns are matched by name so they can be in any order.
+// Problems with the file itself are reported on row 0, err is only set when the file can't be read at all.
+func parseUserCSV(data string, fileErr *ImportFileError) (users []fileUser, err error) {
+
+	reader := csv.NewReader(strings.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		fileErr.add("file", 0, "Import file is empty")
+		err = nil
+		return
+	}
+	if err != nil {
+		fileErr.add("file", 0, err.Error())
+		err = nil
+		return
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"userid", "xp"} {
+		if _, ok := columns[required]; !ok {
+			fileErr.add("file", 0, fmt.Sprintf("Missing column %s", required))
+			return
+		}
+	}
+
+	get := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	// The header is line 1, quoted fields spanning several lines are rare enough to not be worth tracking.
+	line := 1
+	for {
+		var record []string
+		record, err = reader.Read()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		line++
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				fileErr.add("file", 0, err.Error())
+				err = nil
+				return
+			}
+			fileErr.add("users", line, parseErr.Err.Error())
+			err = nil
+			continue
+		}
+		if len(record) != len(header) {
+			fileErr.add("users", line, fmt.Sprintf("Expected %d fields, got %d", len(header), len(record)))
+			continue
+		}
+
+		user := fileUser{
+			UserID:   get(record, "userid"),
+			Nickname: get(record, "nickname"),
+			Avatar:   get(record, "avatar"),
+			row:      line,
+		}
+		user.XP, err = strconv.ParseInt(get(record, "xp"), 10, 64)
+		if err != nil {
+			fileErr.add("users", line, "xp is not a whole number")
+			err = nil
+			continue
+		}
+		users = append(users, user)
+	}
+
+}
+
+func (file importFile) validate(fileErr *ImportFileError) {
+
+	seenUsers := make(map[string]int)
+	for _, user := range file.Users {
+		for _, problem := range (importedUser{UserID: user.UserID, Nickname: user.Nickname, Avatar: user.Avatar, XP: user.XP}).problems() {
+			fileErr.add("users", user.row, problem)
+		}
+		if !isSnowflake(user.UserID) {
+			continue
+		}
+		if first, ok := seenUsers[user.UserID]; ok {
+			fileErr.add("users", user.row, fmt.Sprintf("Duplicate of row %d", first))
+		} else {
+			seenUsers[user.UserID] = user.row
+		}
+	}
+
+	seenRewards := make(map[string]int)
+	for _, reward := range file.RoleRewards {
+		for _, problem := range reward.imported().problems() {
+			fileErr.add("roleRewards", reward.row, problem)
+		}
+		if !isSnowflake(reward.RoleID) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", reward.RoleID, reward.Level)
+		if first, ok := seenRewards[key]; ok {
+			fileErr.add("roleRewards", reward.row, fmt.Sprintf("Duplicate of row %d", first))
+		} else {
+			seenRewards[key] = reward.row
+		}
+	}
+
+}
+
+// problems lists why the database would refuse the user, file and remote imports check rows the same way.
+func (u importedUser) problems() (problems []string) {
+	if !isSnowflake(u.UserID) {
+		problems = append(problems, "Invalid user ID")
+	}
+	if u.XP < 0 || u.XP > maxImportXP {
+		problems = append(problems, fmt.Sprintf("xp must be between 0 and %d", int64(maxImportXP)))
+	}
+	if utf8.RuneCountInString(u.Nickname) > 32 {
+		problems = append(problems, "Nickname is longer than 32 characters")
+	}
+	if len(u.Avatar) > 34 {
+		problems = append(problems, "Avatar is longer than 34 characters")
+	}
+	return
+}
+
+func (r importedRoleReward) problems() (problems []string) {
+	if !isSnowflake(r.RoleID) {
+		problems = append(problems, "Invalid role ID")
+	}
+	if r.Level < 0 || r.Level > levelcurve.MaxLevel {
+		problems = append(problems, fmt.Sprintf("Level must be between 0 and %d", levelcurve.MaxLevel))
+	}
+	if r.Color < 0 || r.Color > 0xFFFFFF {
+		problems = append(problems, "Color must be between 0 and 16777215")
+	}
+	if r.ExpiresAfter != nil && (*r.ExpiresAfter <= 0 || *r.ExpiresAfter > maxExpiresAfter) {
+		problems = append(problems, fmt.Sprintf("expiresAfter must be between 1 and %d seconds", maxExpiresAfter))
+	}
+	if r.TopN != nil && (*r.TopN <= 0 || *r.TopN > maxTopN) {
+		problems = append(problems, fmt.Sprintf("topN must be between 1 and %d", maxTopN))
+	}
+	if r.TopWindowDays != nil {
+		if r.TopN == nil {
+			problems = append(problems, "topWindowDays needs topN")
+		} else if *r.TopWindowDays <= 0 || *r.TopWindowDays > maxTopWindowDays {
+			problems = append(problems, fmt.Sprintf("topWindowDays must be between 1 and %d", maxTopWindowDays))
+		}
+	}
+	return
+}
+
+// entries converts a validated file into the rows writeImport expects.
+func (file importFile) entries() (users []importedUser, roleRewards []importedRoleReward) {
+
+	users = make([]importedUser, 0, len(file.Users))
+	for _, user := range file.Users {
+		users = append(users, importedUser{
+			UserID:   user.UserID,
+			Nickname: user.Nickname,
+			Avatar:   user.Avatar,
+			XP:       user.XP,
+		})
+	}
+
+	roleRewards = make([]importedRoleReward, 0, len(file.RoleRewards))
+	for _, reward := range file.RoleRewards {
+		roleRewards = append(roleRewards, reward.imported())
+	}
+	return
+
+}
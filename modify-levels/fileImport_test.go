@@ -0,0 +1,111 @@
+package remmodifylevels
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseImportFileCSV(t *testing.T) {
+
+	data := "xp,userID,nickname\n1500,196249128286552064,Rem\n0, 206249128286552064 ,\n"
+	file, err := parseImportFile("csv", data)
+	if err != nil {
+		t.Errorf("Failed to parse csv: %s\n", err)
+		return
+	}
+
+	if len(file.Users) != 2 {
+		t.Errorf("Expected 2 users, got %d\n", len(file.Users))
+		return
+	}
+	if file.Users[0].XP != 1500 || file.Users[0].Nickname != "Rem" {
+		t.Errorf("Unexpected first user %v\n", file.Users[0])
+	}
+	if file.Users[1].UserID != "206249128286552064" {
+		t.Errorf("Expected trimmed user ID, got %q\n", file.Users[1].UserID)
+	}
+
+}
+
+func TestParseImportFileJSON(t *testing.T) {
+
+	data := `{"guildID":"1","users":[{"userID":"196249128286552064","nickname":"Rem","avatar":"","xp":100}],"roleRewards":[{"roleID":"806249128286552064","level":5,"color":255,"persistent":true,"expiresAfter":null,"topN":10,"topWindowDays":7}]}`
+	file, err := parseImportFile("json", data)
+	if err != nil {
+		t.Errorf("Failed to parse json: %s\n", err)
+		return
+	}
+
+	users, roleRewards := file.entries()
+	if len(users) != 1 || len(roleRewards) != 1 {
+		t.Errorf("Expected 1 user and 1 role reward, got %d and %d\n", len(users), len(roleRewards))
+		return
+	}
+	reward := roleRewards[0]
+	if !reward.Persistent || reward.Color != 255 || reward.ExpiresAfter != nil || reward.TopN == nil || *reward.TopN != 10 || reward.TopWindowDays == nil || *reward.TopWindowDays != 7 {
+		t.Errorf("Unexpected role reward %v\n", roleRewards[0])
+	}
+
+}
+
+func TestParseImportFileErrors(t *testing.T) {
+
+	data := "userID,xp\n196249128286552064,10\nnotanid,10\n196249128286552064,20\n206249128286552064,lots\n216249128286552064,-5\n226249128286552064\n"
+	_, err := parseImportFile("csv", data)
+
+	var fileErr *ImportFileError
+	if !errors.As(err, &fileErr) {
+		t.Errorf("Expected ImportFileError, got %v\n", err)
+		return
+	}
+
+	expectedRows := map[int]bool{3: true, 4: true, 5: true, 6: true, 7: true}
+	if len(fileErr.Errors) != len(expectedRows) {
+		t.Errorf("Expected %d errors, got %v\n", len(expectedRows), fileErr.Errors)
+		return
+	}
+	for _, rowErr := range fileErr.Errors {
+		if !expectedRows[rowErr.Row] {
+			t.Errorf("Unexpected error on row %d: %s\n", rowErr.Row, rowErr.Message)
+		}
+	}
+
+	_, err = parseImportFile("json", `{"roleRewards":[{"roleID":"806249128286552064","level":5,"topWindowDays":7},{"roleID":"806249128286552065","level":5,"expiresAfter":0}]}`)
+	if !errors.As(err, &fileErr) || len(fileErr.Errors) != 2 {
+		t.Errorf("Expected both expiries to be invalid, got %v\n", err)
+	}
+
+	if _, err := parseImportFile("xml", "<users/>"); err == nil {
+		t.Errorf("Expected error for xml format\n")
+	}
+
+	// Files that can't be read at all are still the user's mistake, they are reported as a file-level error.
+	for _, invalid := range []struct{ format, data string }{
+		{"csv", ""},
+		{"csv", "userID,nickname\n196249128286552064,Rem\n"},
+		{"csv", "userID,\"xp\n"},
+		{"json", "{"},
+		{"json", `{"users":[]}`},
+	} {
+		_, err := parseImportFile(invalid.format, invalid.data)
+		if !errors.As(err, &fileErr) || len(fileErr.Errors) != 1 || fileErr.Errors[0].Row != 0 {
+			t.Errorf("Expected a file-level ImportFileError for %s %q, got %v\n", invalid.format, invalid.data, err)
+		}
+	}
+
+}
+
+func TestImportFileErrorLimit(t *testing.T) {
+
+	file := importFile{}
+	for i := 0; i < maxImportErrors+10; i++ {
+		file.Users = append(file.Users, fileUser{UserID: "invalid", row: i + 1})
+	}
+
+	fileErr := &ImportFileError{}
+	file.validate(fileErr)
+	if len(fileErr.Errors) != maxImportErrors || !fileErr.Truncated {
+		t.Errorf("Expected %d errors and truncation, got %d, %t\n", maxImportErrors, len(fileErr.Errors), fileErr.Truncated)
+	}
+
+}
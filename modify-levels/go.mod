@@ -3,6 +3,19 @@ module github.com/yayuyokitano/rem-next/modify-levels
 go 1.16
 
 require (
+	cloud.google.com/go/pubsub v1.3.1
 	github.com/GoogleCloudPlatform/functions-framework-go v1.5.2
 	github.com/jackc/pgx/v4 v4.15.0
+	github.com/yayuyokitano/rem-next/confirmation v0.0.0
+	github.com/yayuyokitano/rem-next/levelcurve v0.0.0
+	github.com/yayuyokitano/rem-next/listtype v0.0.0
+	github.com/yayuyokitano/rem-next/ratelimit v0.0.0
 )
+
+replace github.com/yayuyokitano/rem-next/levelcurve => ../levelcurve
+
+replace github.com/yayuyokitano/rem-next/confirmation => ../confirmation
+
+replace github.com/yayuyokitano/rem-next/ratelimit => ../ratelimit
+
+replace github.com/yayuyokitano/rem-next/listtype => ../listtype
@@ -0,0 +1,516 @@
+package remmodifylevels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/jackc/pgx/v4"
+)
+
+// Imports from other bots run as jobs, a single request can't fetch every page of a large guild before it times out.
+const (
+	importJobsTopic = "importjobs"
+	// Workers hand the job on to a fresh invocation well before the function timeout catches up with them.
+	importWorkerBudget = 7 * time.Minute
+	// The lease outlives the budget, so no other worker claims a job that is still being worked on.
+	// Once it runs out the job is only picked up again when ImportSweeper queues it.
+	importJobLease = 10 * time.Minute
+)
+
+type ImportJob struct {
+	ID            int64          `json:"id"`
+	Source        string         `json:"source"`
+	Merge         string         `json:"merge"`
+	DryRun        bool           `json:"dryRun"`
+	Status        string         `json:"status"`
+	PagesDone     int            `json:"pagesDone"`
+	UsersImported int64          `json:"usersImported"`
+	Errors        []string       `json:"errors"`
+	Result        *ImportPreview `json:"result"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	UpdatedAt     time.Time      `json:"updatedAt"`
+}
+
+// importJobState is what a worker needs to carry on with a job.
+type importJobState struct {
+	ID       int64
+	GuildID  string
+	CallerID string
+	Source   string
+	APIKey   string
+	Merge    string
+	DryRun   bool
+	NextPage int
+	Fetched  bool
+}
+
+type importJobMessage struct {
+	JobID int64 `json:"jobID"`
+}
+
+// PubSubMessage is the payload of the event that triggers ImportWorker.
+type PubSubMessage struct {
+	Data []byte `json:"data"`
+}
+
+func validateImportSource(params LevelParams) (err error) {
+	if params.Source == "file" {
+		return
+	}
+	newImporter, ok := importers[params.Source]
+	if !ok {
+		err = errors.New("Invalid source")
+		return
+	}
+	_, err = newImporter(params.APIKey)
+	return
+}
+
+func enqueueImport(ctx context.Context, params LevelParams) (jobID int64, err error) {
+
+	err = pool.QueryRow(ctx, "INSERT INTO import_jobs (guildID, callerID, source, apiKey, merge, dryRun) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		params.GuildID, params.CallerID, params.Source, params.APIKey, params.Merge, params.DryRun).Scan(&jobID)
+	if err != nil {
+		return
+	}
+
+	err = publishImportJob(ctx, jobID)
+	if err != nil {
+		// Nothing will ever pick the job up, so don't leave it looking queued.
+		if failErr := failImportJob(ctx, jobID, err); failErr != nil {
+			fmt.Println("Failed to mark import job", jobID, "as failed:", failErr)
+		}
+	}
+	return
+
+}
+
+func publishImportJob(ctx context.Context, jobID int64) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(importJobMessage{JobID: jobID})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic(importJobsTopic).Publish(ctx, m).Get(ctx)
+	return
+
+}
+
+// getImportJob fetches a job of the guild, or its latest job when jobID is 0.
+func getImportJob(ctx context.Context, guildID string, jobID int64) (job ImportJob, err error) {
+
+	query := `SELECT id, source, merge, dryRun, status, nextPage, usersImported, errors, result, createdAt, updatedAt
+		FROM import_jobs WHERE guildID = $1 AND (id = $2 OR $2 = 0) ORDER BY id DESC LIMIT 1`
+
+	var result []byte
+	err = pool.QueryRow(ctx, query, guildID, jobID).Scan(
+		&job.ID,
+		&job.Source,
+		&job.Merge,
+		&job.DryRun,
+		&job.Status,
+		&job.PagesDone,
+		&job.UsersImported,
+		&job.Errors,
+		&result,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return
+	}
+
+	if result != nil {
+		job.Result = &ImportPreview{}
+		err = json.Unmarshal(result, job.Result)
+	}
+	return
+
+}
+
+// ImportWorker processes import jobs, it is deployed as its own function triggered by the importjobs topic.
+func ImportWorker(ctx context.Context, m PubSubMessage) error {
+
+	var message importJobMessage
+	if err := json.Unmarshal(m.Data, &message); err != nil {
+		// Retrying a message that can't be read would never help.
+		fmt.Println("Invalid import job message:", err)
+		return nil
+	}
+
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	if err := runImportJob(ctx, message.JobID); err != nil {
+		fmt.Println("Import job", message.JobID, "failed:", err)
+	}
+	return nil
+
+}
+
+// ImportSweeper queues jobs again whose worker died or whose message was lost.
+// It is deployed as its own function, Cloud Scheduler publishes to importsweep to start it.
+func ImportSweeper(ctx context.Context, m PubSubMessage) (err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	// Queued jobs have no lease, they count as lost once they have waited as long as a lease lasts.
+	rows, err := pool.Query(ctx, `SELECT id FROM import_jobs WHERE status IN ('queued', 'running')
+		AND COALESCE(leaseUntil, updatedAt + make_interval(secs => $1)) < NOW()`, importJobLease.Seconds())
+	if err != nil {
+		return
+	}
+	var jobIDs []int64
+	for rows.Next() {
+		var jobID int64
+		err = rows.Scan(&jobID)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	// Workers claim a job through its lease, so a job that is queued twice still only runs once.
+	for _, jobID := range jobIDs {
+		if publishErr := publishImportJob(ctx, jobID); publishErr != nil {
+			fmt.Println("Failed to queue import job", jobID, "again:", publishErr)
+			err = publishErr
+		}
+	}
+	return
+
+}
+
+func runImportJob(ctx context.Context, jobID int64) (err error) {
+
+	job, claimed, err := claimImportJob(ctx, jobID)
+	if err != nil || !claimed {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			if failErr := failImportJob(context.Background(), job.ID, err); failErr != nil {
+				fmt.Println("Failed to mark import job", job.ID, "as failed:", failErr)
+			}
+		}
+	}()
+
+	// A worker that died while merging leaves a fetched job behind, its key is gone and only the merge is left to do.
+	if !job.Fetched {
+		var done bool
+		done, err = fetchImportJob(ctx, job)
+		if err != nil || !done {
+			return
+		}
+	}
+
+	err = finishImportJob(ctx, job)
+	return
+
+}
+
+// fetchImportJob stages pages until the last one is in, done is false when it ran out of time and queued the job again.
+func fetchImportJob(ctx context.Context, job importJobState) (done bool, err error) {
+
+	newImporter, ok := importers[job.Source]
+	if !ok {
+		err = errors.New("Invalid source")
+		return
+	}
+	importer, err := newImporter(job.APIKey)
+	if err != nil {
+		return
+	}
+
+	// Pages are fetched a batch at a time and staged as soon as they arrive, so memory stays flat however big the guild is.
+	concurrency := importConcurrency()
+	deadline := time.Now().Add(importWorkerBudget)
+	for page := job.NextPage; ; page += concurrency {
+		if page >= maxImportPages {
+			err = errors.New("Too many pages")
+			return
+		}
+		if time.Now().After(deadline) {
+			err = yieldImportJob(ctx, job.ID)
+			return
+		}
+
+		var pages []importPage
+		var fetchErr error
+		pages, fetchErr = fetchPages(ctx, importer, job.GuildID, page, concurrency, func(failedPage int, retryErr error) {
+			addImportJobError(ctx, job.ID, fmt.Sprintf("Page %d: %s", failedPage, retryErr))
+		})
+
+		for i, p := range pages {
+			p, rowErrors := p.dropInvalidRows()
+			reportRowErrors(ctx, job.ID, page+i, rowErrors)
+			err = stageImportPage(ctx, job.ID, page+i, p)
+			if err != nil {
+				return
+			}
+		}
+		if fetchErr != nil {
+			err = fetchErr
+			return
+		}
+		if pages[len(pages)-1].Last {
+			done = true
+			return
+		}
+	}
+
+}
+
+// claimImportJob leases the job to this worker, claimed is false when it is finished or another worker holds it.
+func claimImportJob(ctx context.Context, jobID int64) (job importJobState, claimed bool, err error) {
+
+	err = pool.QueryRow(ctx, `UPDATE import_jobs SET status = 'running', leaseUntil = NOW() + make_interval(secs => $2), updatedAt = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running') AND (leaseUntil IS NULL OR leaseUntil < NOW())
+		RETURNING id, guildID, callerID, source, apiKey, merge, dryRun, nextPage, fetched`, jobID, importJobLease.Seconds()).Scan(
+		&job.ID,
+		&job.GuildID,
+		&job.CallerID,
+		&job.Source,
+		&job.APIKey,
+		&job.Merge,
+		&job.DryRun,
+		&job.NextPage,
+		&job.Fetched,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		return
+	}
+	claimed = err == nil
+	return
+
+}
+
+// yieldImportJob releases the lease and queues the job again, the next worker resumes at the checkpoint.
+func yieldImportJob(ctx context.Context, jobID int64) (err error) {
+
+	_, err = pool.Exec(ctx, "UPDATE import_jobs SET leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", jobID)
+	if err != nil {
+		return
+	}
+
+	err = publishImportJob(ctx, jobID)
+	return
+
+}
+
+// stageImportPage stores a page and moves the checkpoint past it in one go, so a page is never staged twice.
+// The API key is cleared together with the last page, nothing after fetching needs it.
+func stageImportPage(ctx context.Context, jobID int64, page int, p importPage) (err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	userIDs := make([]string, 0, len(p.Users))
+	nicknames := make([]string, 0, len(p.Users))
+	avatars := make([]string, 0, len(p.Users))
+	xp := make([]int64, 0, len(p.Users))
+	for _, u := range p.Users {
+		userIDs = append(userIDs, u.UserID)
+		nicknames = append(nicknames, u.Nickname)
+		avatars = append(avatars, u.Avatar)
+		xp = append(xp, u.XP)
+	}
+
+	// Users already staged keep their first row, leaderboard pages can overlap when users move between requests.
+	tag, err := tx.Exec(ctx, `INSERT INTO import_job_users (jobID, userID, nickname, avatar, xp)
+		SELECT $1, * FROM unnest($2::text[], $3::text[], $4::text[], $5::bigint[]) ON CONFLICT DO NOTHING`,
+		jobID, userIDs, nicknames, avatars, xp)
+	if err != nil {
+		return
+	}
+
+	for _, r := range p.RoleRewards {
+		_, err = tx.Exec(ctx, `INSERT INTO import_job_rolerewards (jobID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT DO NOTHING`,
+			jobID, r.RoleID, r.Level, r.Color, r.Persistent, r.ExpiresAfter, r.TopN, r.TopWindowDays)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE import_jobs SET nextPage = $2, usersImported = usersImported + $3, fetched = $4, apiKey = CASE WHEN $4 THEN '' ELSE apiKey END, updatedAt = NOW()
+		WHERE id = $1`, jobID, page+1, tag.RowsAffected(), p.Last)
+	if err != nil {
+		return
+	}
+
+	if p.Settings != nil {
+		var settings []byte
+		settings, err = json.Marshal(p.Settings)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec(ctx, "UPDATE import_jobs SET settings = $2 WHERE id = $1", jobID, settings)
+		if err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	return
+
+}
+
+// finishImportJob merges the staged rows into the guild, or only previews them for dry runs, and records the result.
+func finishImportJob(ctx context.Context, job importJobState) (err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = createImportTables(ctx, tx)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO importxp SELECT userID, nickname, avatar, xp FROM import_job_users WHERE jobID = $1", job.ID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO importrolerewards SELECT roleID, level, color, persistent, expiresAfter, topN, topWindowDays FROM import_job_rolerewards WHERE jobID = $1", job.ID)
+	if err != nil {
+		return
+	}
+
+	var preview ImportPreview
+	if job.DryRun {
+		preview, err = previewStagedImport(ctx, tx, job.GuildID, job.Merge)
+	} else {
+		preview, err = applyStagedImport(ctx, tx, job.GuildID, job.CallerID, job.Source, job.Merge)
+	}
+	if err != nil {
+		return
+	}
+
+	var rawSettings []byte
+	err = tx.QueryRow(ctx, "SELECT settings FROM import_jobs WHERE id = $1", job.ID).Scan(&rawSettings)
+	if err != nil {
+		return
+	}
+	var settings *importedSettings
+	if rawSettings != nil {
+		settings = &importedSettings{}
+		err = json.Unmarshal(rawSettings, settings)
+		if err != nil {
+			return
+		}
+		if !job.DryRun {
+			err = applyImportedSettings(ctx, tx, job.GuildID, settings)
+			if err != nil {
+				return
+			}
+		}
+		report := settings.report()
+		preview.Settings = &report
+	}
+
+	err = clearStagedImport(ctx, tx, job.ID)
+	if err != nil {
+		return
+	}
+
+	result, err := json.Marshal(preview)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "UPDATE import_jobs SET status = 'done', result = $2, apiKey = '', leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", job.ID, result)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil || settings == nil || job.DryRun {
+		return
+	}
+
+	// The job is done by now, a failure to tell the bot is only recorded on it so an admin can save the settings again.
+	if pushErr := pushImportedSettingsToRemraku(ctx, job.GuildID, *settings); pushErr != nil {
+		addImportJobError(ctx, job.ID, fmt.Sprint("Failed to push settings to Remraku: ", pushErr))
+	}
+	return
+
+}
+
+func clearStagedImport(ctx context.Context, tx pgx.Tx, jobID int64) (err error) {
+	_, err = tx.Exec(ctx, "DELETE FROM import_job_users WHERE jobID = $1", jobID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM import_job_rolerewards WHERE jobID = $1", jobID)
+	return
+}
+
+func addImportJobError(ctx context.Context, jobID int64, message string) {
+	_, err := pool.Exec(ctx, "UPDATE import_jobs SET errors = array_append(errors, $2), updatedAt = NOW() WHERE id = $1", jobID, message)
+	if err != nil {
+		fmt.Println("Failed to record import job error:", err)
+	}
+}
+
+func reportRowErrors(ctx context.Context, jobID int64, page int, rowErrors []string) {
+	for i, rowErr := range rowErrors {
+		if i == maxPageRowErrors {
+			addImportJobError(ctx, jobID, fmt.Sprintf("Page %d: %d more invalid rows skipped", page, len(rowErrors)-i))
+			return
+		}
+		addImportJobError(ctx, jobID, fmt.Sprintf("Page %d: %s", page, rowErr))
+	}
+}
+
+func failImportJob(ctx context.Context, jobID int64, cause error) (err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = clearStagedImport(ctx, tx, jobID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "UPDATE import_jobs SET status = 'failed', errors = array_append(errors, $2), apiKey = '', leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", jobID, cause.Error())
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	return
+
+}
@@ -0,0 +1,31 @@
+package remmodifylevels
+
+import (
+	"testing"
+)
+
+func TestValidateImportSource(t *testing.T) {
+
+	valid := []LevelParams{
+		{Source: "MEE6"},
+		{Source: "file"},
+		{Source: "Tatsu", APIKey: "key"},
+	}
+	for _, params := range valid {
+		if err := validateImportSource(params); err != nil {
+			t.Errorf("Expected %s to be valid, got %s\n", params.Source, err)
+		}
+	}
+
+	invalid := []LevelParams{
+		{Source: ""},
+		{Source: "mee6"},
+		{Source: "Amari"},
+	}
+	for _, params := range invalid {
+		if err := validateImportSource(params); err == nil {
+			t.Errorf("Expected error for source %q\n", params.Source)
+		}
+	}
+
+}
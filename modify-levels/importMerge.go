@@ -0,0 +1,282 @@
+package remmodifylevels
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+// Merge strategies decide what happens to users that already have xp in the guild.
+// Replacing wipes the guild first, which is what imports have always done.
+const (
+	mergeReplace    = ""
+	mergeKeepHigher = "keepHigher"
+	mergeSum        = "sum"
+	mergeOverwrite  = "overwrite"
+)
+
+// Dry runs list this many rows of each kind, the counts always cover everything.
+const importPreviewRows = 20
+
+func validateMerge(strategy string) error {
+	switch strategy {
+	case mergeReplace, mergeKeepHigher, mergeSum, mergeOverwrite:
+		return nil
+	}
+	return errors.New("Invalid merge strategy")
+}
+
+// mergeXPSQL is the xp a user ends up with, in terms of their current xp g.xp and the imported s.xp.
+func mergeXPSQL(strategy string) string {
+	switch strategy {
+	case mergeKeepHigher:
+		return "GREATEST(COALESCE(g.xp, 0), s.xp)"
+	case mergeSum:
+		return "COALESCE(g.xp, 0) + s.xp"
+	}
+	return "s.xp"
+}
+
+type ImportRow struct {
+	UserID     string `json:"userID"`
+	Nickname   string `json:"nickname"`
+	CurrentXP  int64  `json:"currentXP"`
+	ImportedXP int64  `json:"importedXP"`
+	ResultXP   int64  `json:"resultXP"`
+}
+
+type ImportPreview struct {
+	Users       int `json:"users"`
+	RoleRewards int `json:"roleRewards"`
+	NewUsers    int `json:"newUsers"`
+	// ConflictingUsers already have xp in the guild, how they end up depends on the merge strategy.
+	ConflictingUsers int `json:"conflictingUsers"`
+	// RemovedUsers are only in the guild and will be lost, this is always 0 when merging.
+	RemovedUsers           int         `json:"removedUsers"`
+	NewRoleRewards         int         `json:"newRoleRewards"`
+	ConflictingRoleRewards int         `json:"conflictingRoleRewards"`
+	RemovedRoleRewards     int         `json:"removedRoleRewards"`
+	Sample                 []ImportRow `json:"sample"`
+	Conflicts              []ImportRow `json:"conflicts"`
+	// Settings is only reported by sources that import settings.
+	Settings *SettingsReport `json:"settings,omitempty"`
+}
+
+// Imports are merged from temporary tables, so the rows never have to be held in memory all at once.
+// They only live until the transaction ends.
+func createImportTables(ctx context.Context, tx pgx.Tx) (err error) {
+	_, err = tx.Exec(ctx, "CREATE TEMP TABLE importxp (userID VARCHAR(20) PRIMARY KEY, nickname VARCHAR(32) NOT NULL, avatar VARCHAR(34) NOT NULL, xp BIGINT NOT NULL) ON COMMIT DROP")
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "CREATE TEMP TABLE importrolerewards (roleID VARCHAR(20), level INTEGER, color INTEGER NOT NULL, persistent BOOL NOT NULL, expiresAfter INTEGER, topN INTEGER, topWindowDays INTEGER, PRIMARY KEY (roleID, level)) ON COMMIT DROP")
+	return
+}
+
+func copyImportRows(ctx context.Context, tx pgx.Tx, users []importedUser, roleRewards []importedRoleReward) (err error) {
+
+	userInsert := make(DBEntries, 0, len(users))
+	for _, u := range users {
+		userInsert = append(userInsert, DBEntry{
+			u.UserID,
+			u.Nickname,
+			u.Avatar,
+			u.XP,
+		})
+	}
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"importxp"},
+		[]string{"userid", "nickname", "avatar", "xp"},
+		pgx.CopyFromRows(userInsert),
+	)
+	if err != nil {
+		return
+	}
+
+	roleRewardInsert := make(DBEntries, 0, len(roleRewards))
+	for _, r := range roleRewards {
+		roleRewardInsert = append(roleRewardInsert, DBEntry{
+			r.RoleID,
+			r.Level,
+			r.Color,
+			r.Persistent,
+			r.ExpiresAfter,
+			r.TopN,
+			r.TopWindowDays,
+		})
+	}
+	_, err = tx.CopyFrom(
+		ctx,
+		pgx.Identifier{"importrolerewards"},
+		[]string{"roleid", "level", "color", "persistent", "expiresafter", "topn", "topwindowdays"},
+		pgx.CopyFromRows(roleRewardInsert),
+	)
+	return
+
+}
+
+// previewStagedImport compares the import tables against the guild.
+func previewStagedImport(ctx context.Context, tx pgx.Tx, guildID string, strategy string) (preview ImportPreview, err error) {
+
+	var currentUsers, currentRoleRewards int
+	err = tx.QueryRow(ctx, `SELECT
+			(SELECT COUNT(*) FROM importxp),
+			(SELECT COUNT(*) FROM importxp s JOIN guildxp g ON g.guildID = $1 AND g.userID = s.userID),
+			(SELECT COUNT(*) FROM guildxp WHERE guildID = $1),
+			(SELECT COUNT(*) FROM importrolerewards),
+			(SELECT COUNT(*) FROM importrolerewards s JOIN rolerewards r ON r.guildID = $1 AND r.roleID = s.roleID AND r.level = s.level),
+			(SELECT COUNT(*) FROM rolerewards WHERE guildID = $1)`, guildID).Scan(
+		&preview.Users,
+		&preview.ConflictingUsers,
+		&currentUsers,
+		&preview.RoleRewards,
+		&preview.ConflictingRoleRewards,
+		&currentRoleRewards,
+	)
+	if err != nil {
+		return
+	}
+
+	preview.NewUsers = preview.Users - preview.ConflictingUsers
+	preview.NewRoleRewards = preview.RoleRewards - preview.ConflictingRoleRewards
+	if strategy == mergeReplace {
+		preview.RemovedUsers = currentUsers - preview.ConflictingUsers
+		preview.RemovedRoleRewards = currentRoleRewards - preview.ConflictingRoleRewards
+	}
+
+	preview.Sample, err = previewRows(ctx, tx, guildID, strategy, "LEFT JOIN")
+	if err != nil {
+		return
+	}
+	preview.Conflicts, err = previewRows(ctx, tx, guildID, strategy, "JOIN")
+	return
+
+}
+
+func previewRows(ctx context.Context, tx pgx.Tx, guildID string, strategy string, join string) (preview []ImportRow, err error) {
+
+	rows, err := tx.Query(ctx, `SELECT s.userID, s.nickname, COALESCE(g.xp, 0), s.xp, `+mergeXPSQL(strategy)+`
+		FROM importxp s `+join+` guildxp g ON g.guildID = $1 AND g.userID = s.userID
+		ORDER BY s.xp DESC, s.userID LIMIT $2`, guildID, importPreviewRows)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	preview = make([]ImportRow, 0)
+	for rows.Next() {
+		var row ImportRow
+		err = rows.Scan(&row.UserID, &row.Nickname, &row.CurrentXP, &row.ImportedXP, &row.ResultXP)
+		if err != nil {
+			return
+		}
+		preview = append(preview, row)
+	}
+	err = rows.Err()
+	return
+
+}
+
+// applyStagedImport merges the import tables into the guild and returns what it compared them against.
+func applyStagedImport(ctx context.Context, tx pgx.Tx, guildID string, callerID string, source string, strategy string) (preview ImportPreview, err error) {
+
+	// Keeps the bot from changing xp between reading it here and writing the merged totals.
+	_, err = tx.Exec(ctx, "SELECT 1 FROM guildxp WHERE guildID = $1 FOR UPDATE", guildID)
+	if err != nil {
+		return
+	}
+
+	preview, err = previewStagedImport(ctx, tx, guildID, strategy)
+	if err != nil {
+		return
+	}
+
+	_, err = takeSnapshot(ctx, tx, guildID, callerID, ledgerSourceImport+source)
+	if err != nil {
+		return
+	}
+	err = pruneSnapshots(ctx, tx, guildID)
+	if err != nil {
+		return
+	}
+
+	if strategy == mergeReplace {
+		err = resetGuild(ctx, tx, guildID, callerID)
+		if err != nil {
+			return
+		}
+	}
+
+	// Some sources don't know nicknames or avatars, so blanks never replace what the guild already has.
+	_, err = tx.Exec(ctx, `WITH merged AS (
+			SELECT s.userID, s.nickname, s.avatar, COALESCE(g.xp, 0) AS oldXP, `+mergeXPSQL(strategy)+` AS xp
+			FROM importxp s LEFT JOIN guildxp g ON g.guildID = $1 AND g.userID = s.userID
+		), ledger AS (
+			INSERT INTO xpledger (guildID, userID, delta, total, source, actorID)
+			SELECT $1, userID, xp - oldXP, xp, $2, $3 FROM merged WHERE xp <> oldXP
+		)
+		INSERT INTO guildxp (guildID, userID, nickname, avatar, xp) SELECT $1, userID, nickname, avatar, xp FROM merged
+		ON CONFLICT (guildID, userID) DO UPDATE SET
+			nickname = COALESCE(NULLIF(EXCLUDED.nickname, ''), guildxp.nickname),
+			avatar = COALESCE(NULLIF(EXCLUDED.avatar, ''), guildxp.avatar),
+			xp = EXCLUDED.xp`, guildID, ledgerSourceImport+source, callerID)
+	if err != nil {
+		return
+	}
+
+	// Levels are derived from xp, so a guild replaced by MEE6 data has to use MEE6's curve to keep the levels it had there.
+	if source == "MEE6" && strategy == mergeReplace {
+		_, err = tx.Exec(ctx, "INSERT INTO guildsettings (guildID, levelCurve) VALUES ($1, $2) ON CONFLICT (guildID) DO UPDATE SET levelCurve = $2", guildID, levelcurve.Config{Type: levelcurve.TypeMEE6})
+		if err != nil {
+			return
+		}
+	}
+
+	// Only overwriting replaces rewards the guild already has, the other strategies only add to them.
+	onConflict := "DO NOTHING"
+	if strategy == mergeOverwrite {
+		onConflict = `DO UPDATE SET color = EXCLUDED.color, persistent = EXCLUDED.persistent,
+			expiresAfter = EXCLUDED.expiresAfter, topN = EXCLUDED.topN, topWindowDays = EXCLUDED.topWindowDays`
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO rolerewards (guildID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays)
+		SELECT $1, roleID, level, color, persistent, expiresAfter, topN, topWindowDays FROM importrolerewards ON CONFLICT (guildID, roleID, level) `+onConflict, guildID)
+	return
+
+}
+
+// writeImport loads normalized rows into the guild in a single transaction, so a failure leaves the old data untouched.
+// Dry runs go through the same steps and roll back, returning only the preview.
+func writeImport(ctx context.Context, guildID string, callerID string, source string, strategy string, dryRun bool, users []importedUser, roleRewards []importedRoleReward) (preview ImportPreview, err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = createImportTables(ctx, tx)
+	if err != nil {
+		return
+	}
+	err = copyImportRows(ctx, tx, users, roleRewards)
+	if err != nil {
+		return
+	}
+
+	if dryRun {
+		preview, err = previewStagedImport(ctx, tx, guildID, strategy)
+		return
+	}
+
+	preview, err = applyStagedImport(ctx, tx, guildID, callerID, source, strategy)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	return
+
+}
@@ -0,0 +1,29 @@
+package remmodifylevels
+
+import (
+	"testing"
+)
+
+func TestMergeStrategies(t *testing.T) {
+
+	expected := map[string]string{
+		mergeReplace:    "s.xp",
+		mergeOverwrite:  "s.xp",
+		mergeKeepHigher: "GREATEST(COALESCE(g.xp, 0), s.xp)",
+		mergeSum:        "COALESCE(g.xp, 0) + s.xp",
+	}
+
+	for strategy, expr := range expected {
+		if err := validateMerge(strategy); err != nil {
+			t.Errorf("Expected %q to be valid, got %s\n", strategy, err)
+		}
+		if got := mergeXPSQL(strategy); got != expr {
+			t.Errorf("%q: expected %s, got %s\n", strategy, expr, got)
+		}
+	}
+
+	if err := validateMerge("average"); err == nil {
+		t.Errorf("Expected error for unknown strategy\n")
+	}
+
+}
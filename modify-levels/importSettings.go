@@ -0,0 +1,315 @@
+package remmodifylevels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/jackc/pgx/v4"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+	"github.com/yayuyokitano/rem-next/listtype"
+)
+
+// Match the limits the settings function enforces.
+const (
+	maxImportXPPerMessage      = 1000
+	maxImportLevelUpMessageLen = 2000
+)
+
+// importedSettings is the part of another bot's configuration Rem has an equivalent for.
+// Nil fields were not part of the import and are left alone.
+type importedSettings struct {
+	XPMin             *int     `json:"xpMin,omitempty"`
+	XPMax             *int     `json:"xpMax,omitempty"`
+	LevelUpMessage    *string  `json:"levelUpMessage,omitempty"`
+	AnnounceChannelID *string  `json:"announceChannelID,omitempty"`
+	CumulativeRoles   *bool    `json:"cumulativeRoles,omitempty"`
+	NoXPChannels      []string `json:"noXPChannels,omitempty"`
+	NoXPRoles         []string `json:"noXPRoles,omitempty"`
+	// Unmapped describes settings Rem has nothing for, so admins know what to set up by hand.
+	Unmapped []string `json:"unmapped,omitempty"`
+}
+
+type SettingsReport struct {
+	Applied  []string `json:"applied"`
+	Unmapped []string `json:"unmapped"`
+}
+
+func (s importedSettings) report() (report SettingsReport) {
+
+	report.Applied = make([]string, 0)
+	report.Unmapped = make([]string, 0)
+
+	if s.XPMin != nil && s.XPMax != nil {
+		report.Applied = append(report.Applied, fmt.Sprintf("XP per message set to %d-%d", *s.XPMin, *s.XPMax))
+	}
+	if s.LevelUpMessage != nil {
+		report.Applied = append(report.Applied, "Level up message")
+	}
+	if s.AnnounceChannelID != nil {
+		report.Applied = append(report.Applied, "Level up announcement channel")
+	}
+	if s.CumulativeRoles != nil {
+		report.Applied = append(report.Applied, fmt.Sprintf("Cumulative role rewards turned %s", onOff(*s.CumulativeRoles)))
+	}
+	if len(s.NoXPChannels) > 0 {
+		report.Applied = append(report.Applied, fmt.Sprintf("%d channels blocked from gaining XP", len(s.NoXPChannels)))
+	}
+	if len(s.NoXPRoles) > 0 {
+		report.Applied = append(report.Applied, fmt.Sprintf("%d roles blocked from gaining XP", len(s.NoXPRoles)))
+	}
+	report.Unmapped = append(report.Unmapped, s.Unmapped...)
+	return
+
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// applyImportedSettings writes the settings alongside the imported levels, in the same transaction.
+// Settings that turn out to have nothing to apply to are moved to Unmapped, so the report only lists what changed.
+func applyImportedSettings(ctx context.Context, tx pgx.Tx, guildID string, s *importedSettings) (err error) {
+
+	if s.XPMin != nil && s.XPMax != nil {
+		_, err = tx.Exec(ctx, "INSERT INTO guildsettings (guildID, xpMin, xpMax) VALUES ($1, $2, $3) ON CONFLICT (guildID) DO UPDATE SET xpMin = $2, xpMax = $3", guildID, *s.XPMin, *s.XPMax)
+		if err != nil {
+			return
+		}
+	}
+	if s.LevelUpMessage != nil {
+		_, err = tx.Exec(ctx, "INSERT INTO guildsettings (guildID, levelUpMessage) VALUES ($1, $2) ON CONFLICT (guildID) DO UPDATE SET levelUpMessage = $2", guildID, *s.LevelUpMessage)
+		if err != nil {
+			return
+		}
+	}
+	if s.AnnounceChannelID != nil {
+		_, err = tx.Exec(ctx, "INSERT INTO guildsettings (guildID, announceChannelID) VALUES ($1, $2) ON CONFLICT (guildID) DO UPDATE SET announceChannelID = $2", guildID, *s.AnnounceChannelID)
+		if err != nil {
+			return
+		}
+	}
+	if s.CumulativeRoles != nil {
+		var updated bool
+		err = tx.QueryRow(ctx, "UPDATE guilds SET cumulativeRoles = $2, roleStacking = CASE WHEN $2 THEN 'cumulative' ELSE 'highest' END, roleStackingKeep = 1 WHERE guildID = $1 RETURNING TRUE", guildID, *s.CumulativeRoles).Scan(&updated)
+		// Guilds only get a row once Rem has been set up on them.
+		if errors.Is(err, pgx.ErrNoRows) {
+			s.CumulativeRoles = nil
+			s.Unmapped = append(s.Unmapped, "Cumulative role rewards, Rem has not been set up on the guild yet")
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	for _, channelID := range s.NoXPChannels {
+		_, err = tx.Exec(ctx, "INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) VALUES ($1, 'channel', $2, $3, TRUE) ON CONFLICT (guildID, targetKind, targetID, listType) DO UPDATE SET state = TRUE", guildID, channelID, listtype.XPGain)
+		if err != nil {
+			return
+		}
+	}
+	for _, roleID := range s.NoXPRoles {
+		_, err = tx.Exec(ctx, "INSERT INTO blocklistentries (guildID, targetKind, targetID, listType, state) VALUES ($1, 'role', $2, $3, TRUE) ON CONFLICT (guildID, targetKind, targetID, listType) DO UPDATE SET state = TRUE", guildID, roleID, listtype.XPGain)
+		if err != nil {
+			return
+		}
+	}
+	return
+
+}
+
+// settings maps the configuration MEE6 sends along with the first leaderboard page.
+func (m Mee6) settings() (s importedSettings) {
+
+	if len(m.XPPerMessage) == 2 {
+		// Rem has no xp rate, so it is folded into the amount per message instead.
+		rate := m.XPRate
+		if rate <= 0 {
+			rate = 1
+		}
+		xpMin := int(math.Round(float64(m.XPPerMessage[0]) * rate))
+		xpMax := int(math.Round(float64(m.XPPerMessage[1]) * rate))
+		if xpMin >= 0 && xpMin <= xpMax && xpMax <= maxImportXPPerMessage {
+			s.XPMin, s.XPMax = &xpMin, &xpMax
+		} else {
+			s.Unmapped = append(s.Unmapped, fmt.Sprintf("XP per message of %d-%d is outside the 0-%d Rem allows", xpMin, xpMax, maxImportXPPerMessage))
+		}
+	}
+
+	switch m.AnnouncementType {
+	case mee6AnnounceCustomChannel:
+		if isSnowflake(m.AnnouncementChannel) {
+			s.AnnounceChannelID = &m.AnnouncementChannel
+			s.LevelUpMessage = &m.LevelUpMessage
+		} else {
+			s.Unmapped = append(s.Unmapped, "Level up announcement channel is missing")
+		}
+		break
+	case mee6AnnounceCurrentChannel:
+		// Rem announces in the channel the level was gained in when no channel is set.
+		empty := ""
+		s.AnnounceChannelID = &empty
+		s.LevelUpMessage = &m.LevelUpMessage
+		break
+	case mee6AnnounceDM:
+		s.Unmapped = append(s.Unmapped, "Level up messages sent as DMs")
+		break
+	case mee6AnnounceDisabled:
+		// An empty message turns announcements off.
+		empty := ""
+		s.LevelUpMessage = &empty
+		break
+	case "":
+		break
+	default:
+		s.Unmapped = append(s.Unmapped, fmt.Sprintf("Level up announcement type %q", m.AnnouncementType))
+		break
+	}
+
+	// Anything longer wouldn't fit in guildsettings and would fail the whole import.
+	if s.LevelUpMessage != nil && utf8.RuneCountInString(*s.LevelUpMessage) > maxImportLevelUpMessageLen {
+		s.LevelUpMessage = nil
+		s.Unmapped = append(s.Unmapped, fmt.Sprintf("Level up message is longer than the %d characters Rem allows", maxImportLevelUpMessageLen))
+	}
+
+	if m.StackRoleRewards != nil {
+		s.CumulativeRoles = m.StackRoleRewards
+	}
+
+	for _, channelID := range m.NoXPChannels {
+		if isSnowflake(channelID) {
+			s.NoXPChannels = append(s.NoXPChannels, channelID)
+		}
+	}
+	for _, roleID := range m.NoXPRoles {
+		if isSnowflake(roleID) {
+			s.NoXPRoles = append(s.NoXPRoles, roleID)
+		}
+	}
+	return
+
+}
+
+// guildSettings matches Settings in the settings function, remraku expects the whole of it in a settings message.
+type guildSettings struct {
+	XPMin             int               `json:"xpMin"`
+	XPMax             int               `json:"xpMax"`
+	Cooldown          int               `json:"cooldown"`
+	LevelUpMessage    string            `json:"levelUpMessage"`
+	AnnounceChannelID string            `json:"announceChannelID"`
+	PublicLeaderboard bool              `json:"publicLeaderboard"`
+	LevelCurve        levelcurve.Config `json:"levelCurve"`
+}
+
+type settingsMessage struct {
+	Type     string        `json:"type"`
+	GuildID  string        `json:"guildID"`
+	Settings guildSettings `json:"settings"`
+}
+
+// stacking matches Stacking in the role-stacking function.
+type stacking struct {
+	Mode            string `json:"mode"`
+	Keep            int    `json:"keep"`
+	CumulativeRoles bool   `json:"cumulativeRoles"`
+}
+
+type stackingMessage struct {
+	Type     string   `json:"type"`
+	GuildID  string   `json:"guildID"`
+	Stacking stacking `json:"stacking"`
+}
+
+type blocklistChange struct {
+	Kind      string        `json:"kind"`
+	ChannelID string        `json:"channelID,omitempty"`
+	RoleID    string        `json:"roleID,omitempty"`
+	ListType  listtype.Type `json:"listType"`
+	State     bool          `json:"state"`
+}
+
+type bulkBlocklistMessage struct {
+	Type      string            `json:"type"`
+	GuildID   string            `json:"guildID"`
+	Changes   []blocklistChange `json:"changes"`
+	ListTypes []listtype.Type   `json:"listTypes"`
+}
+
+// pushImportedSettingsToRemraku tells the bot about the settings an import applied, once they are committed.
+// It sends the same messages the settings, role-stacking and blocklist functions would have.
+func pushImportedSettingsToRemraku(ctx context.Context, guildID string, s importedSettings) (err error) {
+
+	if s.XPMin != nil || s.LevelUpMessage != nil || s.AnnounceChannelID != nil {
+		var settings guildSettings
+		err = pool.QueryRow(ctx, "SELECT xpMin, xpMax, cooldown, levelUpMessage, announceChannelID, publicLeaderboard, levelCurve FROM guildsettings WHERE guildID = $1", guildID).Scan(
+			&settings.XPMin,
+			&settings.XPMax,
+			&settings.Cooldown,
+			&settings.LevelUpMessage,
+			&settings.AnnounceChannelID,
+			&settings.PublicLeaderboard,
+			&settings.LevelCurve,
+		)
+		if err != nil {
+			return
+		}
+		err = publishToRemraku(ctx, settingsMessage{Type: "settings", GuildID: guildID, Settings: settings})
+		if err != nil {
+			return
+		}
+	}
+
+	if s.CumulativeRoles != nil {
+		var st stacking
+		err = pool.QueryRow(ctx, "SELECT roleStacking, roleStackingKeep, cumulativeRoles FROM guilds WHERE guildID = $1", guildID).Scan(&st.Mode, &st.Keep, &st.CumulativeRoles)
+		if err != nil {
+			return
+		}
+		err = publishToRemraku(ctx, stackingMessage{Type: "rolestacking", GuildID: guildID, Stacking: st})
+		if err != nil {
+			return
+		}
+	}
+
+	changes := make([]blocklistChange, 0, len(s.NoXPChannels)+len(s.NoXPRoles))
+	for _, channelID := range s.NoXPChannels {
+		changes = append(changes, blocklistChange{Kind: "channel", ChannelID: channelID, ListType: listtype.XPGain, State: true})
+	}
+	for _, roleID := range s.NoXPRoles {
+		changes = append(changes, blocklistChange{Kind: "role", RoleID: roleID, ListType: listtype.XPGain, State: true})
+	}
+	if len(changes) > 0 {
+		err = publishToRemraku(ctx, bulkBlocklistMessage{Type: "blocklistbulk", GuildID: guildID, Changes: changes, ListTypes: listtype.Names()})
+	}
+	return
+
+}
+
+func publishToRemraku(ctx context.Context, message interface{}) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(ctx, m).Get(ctx)
+	return
+
+}
@@ -0,0 +1,78 @@
+package remmodifylevels
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMee6Settings(t *testing.T) {
+
+	stack := true
+	m := Mee6{
+		XPPerMessage:        []int{15, 25},
+		XPRate:              1.5,
+		NoXPRoles:           []string{"806249128286552064", "muted"},
+		NoXPChannels:        []string{"947537023839912038", "general"},
+		AnnouncementType:    mee6AnnounceCustomChannel,
+		AnnouncementChannel: "947537023839912039",
+		LevelUpMessage:      "GG {player}, you just advanced to level {level}!",
+		StackRoleRewards:    &stack,
+	}
+
+	s := m.settings()
+	if s.XPMin == nil || s.XPMax == nil || *s.XPMin != 23 || *s.XPMax != 38 {
+		t.Errorf("Expected xp rate to be folded into 23-38, got %v-%v\n", s.XPMin, s.XPMax)
+	}
+	if s.AnnounceChannelID == nil || *s.AnnounceChannelID != m.AnnouncementChannel {
+		t.Errorf("Expected announcement channel %s, got %v\n", m.AnnouncementChannel, s.AnnounceChannelID)
+	}
+	if s.LevelUpMessage == nil || *s.LevelUpMessage != m.LevelUpMessage {
+		t.Errorf("Expected level up message to be copied, got %v\n", s.LevelUpMessage)
+	}
+	if s.CumulativeRoles == nil || !*s.CumulativeRoles {
+		t.Errorf("Expected cumulative roles to be on\n")
+	}
+	if len(s.NoXPChannels) != 1 {
+		t.Errorf("Expected invalid channel to be skipped, got %v\n", s.NoXPChannels)
+	}
+	if len(s.NoXPRoles) != 1 {
+		t.Errorf("Expected invalid role to be skipped, got %v\n", s.NoXPRoles)
+	}
+
+	report := s.report()
+	if len(report.Applied) != 6 || len(report.Unmapped) != 0 {
+		t.Errorf("Expected 6 applied and no unmapped settings, got %v\n", report)
+	}
+
+}
+
+func TestMee6SettingsUnmapped(t *testing.T) {
+
+	m := Mee6{
+		XPPerMessage:     []int{500, 900},
+		XPRate:           2,
+		AnnouncementType: mee6AnnounceDM,
+	}
+
+	s := m.settings()
+	if s.XPMin != nil || s.XPMax != nil {
+		t.Errorf("Expected out of range xp to be left alone\n")
+	}
+	if s.LevelUpMessage != nil || s.AnnounceChannelID != nil || s.CumulativeRoles != nil {
+		t.Errorf("Expected nothing else to be mapped, got %+v\n", s)
+	}
+	if len(s.Unmapped) != 2 {
+		t.Errorf("Expected 2 unmapped settings, got %v\n", s.Unmapped)
+	}
+
+	s = Mee6{AnnouncementType: mee6AnnounceCurrentChannel, LevelUpMessage: strings.Repeat("ü", maxImportLevelUpMessageLen+1)}.settings()
+	if s.LevelUpMessage != nil || len(s.Unmapped) != 1 {
+		t.Errorf("Expected a level up message that is too long to be left alone, got %v\n", s.Unmapped)
+	}
+
+	s = Mee6{AnnouncementType: mee6AnnounceDisabled}.settings()
+	if s.LevelUpMessage == nil || *s.LevelUpMessage != "" {
+		t.Errorf("Expected disabled announcements to clear the message\n")
+	}
+
+}
@@ -0,0 +1,381 @@
+package remmodifylevels
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Importer reads a guild's leaderboard from another leveling bot, one page at a time.
+type Importer interface {
+	// FetchPage fetches page, counting from 0, and normalizes it.
+	FetchPage(ctx context.Context, guildID string, page int) (p importPage, err error)
+}
+
+type importPage struct {
+	Users       []importedUser
+	RoleRewards []importedRoleReward
+	// Settings is only set on the first page, and only by importers that can read the bot's configuration.
+	Settings *importedSettings
+	// Last is set when there are no more pages after this one.
+	Last bool
+}
+
+// importers maps import sources to their importers, apiKey is ignored by bots with public leaderboards.
+var importers = map[string]func(apiKey string) (Importer, error){
+	"MEE6": func(string) (Importer, error) {
+		return mee6Importer{baseURL: "https://mee6.xyz"}, nil
+	},
+	"Tatsu": func(apiKey string) (Importer, error) {
+		return tatsuImporter{baseURL: "https://api.tatsu.gg", apiKey: apiKey}, requireAPIKey(apiKey)
+	},
+	"Amari": func(apiKey string) (Importer, error) {
+		return amariImporter{baseURL: "https://amaribot.com", apiKey: apiKey}, requireAPIKey(apiKey)
+	},
+	"Arcane": func(apiKey string) (Importer, error) {
+		return arcaneImporter{baseURL: "https://arcane.bot", apiKey: apiKey}, requireAPIKey(apiKey)
+	},
+}
+
+func requireAPIKey(apiKey string) error {
+	if apiKey == "" {
+		return errors.New("Missing API key")
+	}
+	return nil
+}
+
+// Every source is capped so a misbehaving API can't keep an import going forever.
+const maxImportPages = 10000
+
+// fetchPages fetches count pages from first at the same time and returns them in order.
+// Anything after the last page is dropped, and when a page fails the pages before it are still returned.
+func fetchPages(ctx context.Context, importer Importer, guildID string, first int, count int, onRetry func(page int, err error)) (pages []importPage, err error) {
+
+	results := make([]importPage, count)
+	errs := make([]error, count)
+
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = fetchPage(ctx, importer, guildID, first+i, func(err error) {
+				onRetry(first+i, err)
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			err = errs[i]
+			return
+		}
+		pages = append(pages, results[i])
+		if results[i].Last {
+			return
+		}
+	}
+	return
+
+}
+
+// fetchPage gives every page a second try, since leaderboard APIs fail every now and then. onRetry gets the first error.
+func fetchPage(ctx context.Context, importer Importer, guildID string, page int, onRetry func(error)) (p importPage, err error) {
+
+	p, err = importer.FetchPage(ctx, guildID, page)
+	if err != nil {
+		onRetry(err)
+		p, err = importer.FetchPage(ctx, guildID, page)
+	}
+	return
+
+}
+
+// Only the first invalid rows of a page are described, the rest are only counted.
+const maxPageRowErrors = 10
+
+// dropInvalidRows keeps the rows the database would accept and describes the ones it drops,
+// so one bad row from another bot can't fail the whole import.
+func (p importPage) dropInvalidRows() (valid importPage, rowErrors []string) {
+
+	valid = p
+	valid.Users = make([]importedUser, 0, len(p.Users))
+	for _, user := range p.Users {
+		if problems := user.problems(); len(problems) > 0 {
+			rowErrors = append(rowErrors, fmt.Sprintf("User %q: %s", user.UserID, strings.Join(problems, ", ")))
+			continue
+		}
+		valid.Users = append(valid.Users, user)
+	}
+
+	valid.RoleRewards = make([]importedRoleReward, 0, len(p.RoleRewards))
+	for _, reward := range p.RoleRewards {
+		if problems := reward.problems(); len(problems) > 0 {
+			rowErrors = append(rowErrors, fmt.Sprintf("Role reward %q at level %d: %s", reward.RoleID, reward.Level, strings.Join(problems, ", ")))
+			continue
+		}
+		valid.RoleRewards = append(valid.RoleRewards, reward)
+	}
+	return
+
+}
+
+// getJSON fetches url and decodes it into v, errors are named after source so the dashboard can tell them apart.
+func getJSON(ctx context.Context, source string, url string, apiKey string, v interface{}) (err error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return
+	}
+	if apiKey != "" {
+		req.Header.Set("Authorization", apiKey)
+	}
+
+	resp, err := importClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		err = errors.New(source + "AuthError")
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Failed to get page")
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return
+
+}
+
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Avatar   string `json:"avatar"`
+	Xp       int64  `json:"xp"`
+	Level    int    `json:"level"`
+}
+
+type RoleReward struct {
+	Level      int  `json:"rank"`
+	Persistent bool `json:"persistent"`
+	Role       struct {
+		ID    string `json:"id"`
+		Color int    `json:"color"`
+	} `json:"role"`
+}
+
+const (
+	mee6AnnounceCurrentChannel = "CURRENT_CHANNEL"
+	mee6AnnounceCustomChannel  = "CUSTOM_CHANNEL"
+	mee6AnnounceDM             = "DM"
+	mee6AnnounceDisabled       = "DISABLED"
+)
+
+type Mee6 struct {
+	Page        int          `json:"page"`
+	Users       []User       `json:"players"`
+	RoleRewards []RoleReward `json:"role_rewards"`
+	// XPPerMessage is the minimum and maximum, XPRate multiplies both.
+	XPPerMessage        []int    `json:"xp_per_message"`
+	XPRate              float64  `json:"xp_rate"`
+	NoXPRoles           []string `json:"no_xp_roles"`
+	NoXPChannels        []string `json:"no_xp_channels"`
+	AnnouncementType    string   `json:"announcement_type"`
+	AnnouncementChannel string   `json:"announcement_channel"`
+	LevelUpMessage      string   `json:"level_up_message"`
+	StackRoleRewards    *bool    `json:"stack_role_rewards"`
+}
+
+type mee6Importer struct {
+	baseURL string
+}
+
+func (i mee6Importer) FetchPage(ctx context.Context, guildID string, page int) (p importPage, err error) {
+
+	var m Mee6
+	err = getJSON(ctx, "MEE6", fmt.Sprintf("%s/api/plugins/levels/leaderboard/%s?page=%d", i.baseURL, guildID, page), "", &m)
+	if err != nil {
+		return
+	}
+
+	for _, user := range m.Users {
+		p.Users = append(p.Users, importedUser{
+			UserID:   user.ID,
+			Nickname: user.Username,
+			Avatar:   user.Avatar,
+			XP:       user.Xp,
+		})
+	}
+
+	// MEE6 sends the role rewards and settings with every page, only the first copy is kept.
+	if page == 0 {
+		for _, r := range m.RoleRewards {
+			p.RoleRewards = append(p.RoleRewards, importedRoleReward{
+				RoleID:     r.Role.ID,
+				Level:      r.Level,
+				Color:      r.Role.Color,
+				Persistent: r.Persistent,
+			})
+		}
+		settings := m.settings()
+		p.Settings = &settings
+	}
+
+	p.Last = len(m.Users) == 0
+	return
+
+}
+
+// Tatsu only ranks users by score, it has no role rewards or profile data.
+type tatsuImporter struct {
+	baseURL string
+	apiKey  string
+}
+
+const tatsuPageSize = 100
+
+type tatsuRankings struct {
+	Rankings []struct {
+		UserID string `json:"user_id"`
+		Score  int64  `json:"score"`
+	} `json:"rankings"`
+}
+
+func (i tatsuImporter) FetchPage(ctx context.Context, guildID string, page int) (p importPage, err error) {
+
+	var t tatsuRankings
+	err = getJSON(ctx, "Tatsu", fmt.Sprintf("%s/v1/guilds/%s/rankings/all?offset=%d", i.baseURL, guildID, page*tatsuPageSize), i.apiKey, &t)
+	if err != nil {
+		return
+	}
+
+	for _, r := range t.Rankings {
+		p.Users = append(p.Users, importedUser{
+			UserID: r.UserID,
+			XP:     r.Score,
+		})
+	}
+
+	p.Last = len(t.Rankings) < tatsuPageSize
+	return
+
+}
+
+type amariImporter struct {
+	baseURL string
+	apiKey  string
+}
+
+const amariPageSize = 1000
+
+type amariLeaderboard struct {
+	Data []struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Exp      int64  `json:"exp"`
+	} `json:"data"`
+}
+
+type amariRewards struct {
+	Data []struct {
+		RoleID string `json:"roleID"`
+		Level  int    `json:"level"`
+	} `json:"data"`
+}
+
+func (i amariImporter) FetchPage(ctx context.Context, guildID string, page int) (p importPage, err error) {
+
+	// Amari counts pages from 1.
+	var a amariLeaderboard
+	err = getJSON(ctx, "Amari", fmt.Sprintf("%s/api/v1/guild/leaderboard/%s?page=%d&limit=%d", i.baseURL, guildID, page+1, amariPageSize), i.apiKey, &a)
+	if err != nil {
+		return
+	}
+
+	for _, user := range a.Data {
+		p.Users = append(p.Users, importedUser{
+			UserID:   user.ID,
+			Nickname: user.Username,
+			XP:       user.Exp,
+		})
+	}
+
+	// Rewards live on their own endpoint, which is small enough to fetch in one go.
+	if page == 0 {
+		var r amariRewards
+		err = getJSON(ctx, "Amari", fmt.Sprintf("%s/api/v1/guild/rewards/%s?page=1&limit=%d", i.baseURL, guildID, amariPageSize), i.apiKey, &r)
+		if err != nil {
+			return
+		}
+		for _, reward := range r.Data {
+			p.RoleRewards = append(p.RoleRewards, importedRoleReward{
+				RoleID: reward.RoleID,
+				Level:  reward.Level,
+			})
+		}
+	}
+
+	p.Last = len(a.Data) < amariPageSize
+	return
+
+}
+
+type arcaneImporter struct {
+	baseURL string
+	apiKey  string
+}
+
+const arcanePageSize = 100
+
+type arcaneLeaderboard struct {
+	Members []struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+		Avatar   string `json:"avatar"`
+		XP       int64  `json:"xp"`
+	} `json:"members"`
+	RoleRewards []struct {
+		RoleID string `json:"roleID"`
+		Level  int    `json:"level"`
+	} `json:"roleRewards"`
+	HasMore bool `json:"hasMore"`
+}
+
+func (i arcaneImporter) FetchPage(ctx context.Context, guildID string, page int) (p importPage, err error) {
+
+	var a arcaneLeaderboard
+	err = getJSON(ctx, "Arcane", fmt.Sprintf("%s/api/guilds/%s/levels?page=%d&limit=%d", i.baseURL, guildID, page, arcanePageSize), i.apiKey, &a)
+	if err != nil {
+		return
+	}
+
+	for _, member := range a.Members {
+		p.Users = append(p.Users, importedUser{
+			UserID:   member.ID,
+			Nickname: member.Username,
+			Avatar:   member.Avatar,
+			XP:       member.XP,
+		})
+	}
+
+	if page == 0 {
+		for _, reward := range a.RoleRewards {
+			p.RoleRewards = append(p.RoleRewards, importedRoleReward{
+				RoleID: reward.RoleID,
+				Level:  reward.Level,
+			})
+		}
+	}
+
+	p.Last = !a.HasMore
+	return
+
+}
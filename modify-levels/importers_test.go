@@ -0,0 +1,236 @@
+package remmodifylevels
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testImportGuildID = "106249128286552064"
+
+// fakeLeaderboard serves fixed responses by path and query, and rejects requests without the expected key.
+func fakeLeaderboard(t *testing.T, apiKey string, responses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != apiKey {
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, ok := responses[request.URL.RequestURI()]
+		if !ok {
+			t.Errorf("Unexpected request %s\n", request.URL.RequestURI())
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(writer, body)
+	}))
+}
+
+// fetchImport reads every page the way the import worker does.
+func fetchImport(ctx context.Context, importer Importer, guildID string) (users []importedUser, roleRewards []importedRoleReward, err error) {
+	for page := 0; page < maxImportPages; page++ {
+		var p importPage
+		p, err = fetchPage(ctx, importer, guildID, page, func(error) {})
+		if err != nil {
+			return
+		}
+		users = append(users, p.Users...)
+		roleRewards = append(roleRewards, p.RoleRewards...)
+		if p.Last {
+			return
+		}
+	}
+	err = errors.New("Too many pages")
+	return
+}
+
+func TestMEE6Importer(t *testing.T) {
+
+	path := "/api/plugins/levels/leaderboard/" + testImportGuildID
+	server := fakeLeaderboard(t, "", map[string]string{
+		path + "?page=0": `{"page":0,"players":[{"id":"196249128286552064","username":"Rem","avatar":"abc","xp":300,"level":2}],"role_rewards":[{"rank":5,"persistent":true,"role":{"id":"806249128286552064","color":255}}],"xp_per_message":[15,25],"xp_rate":1}`,
+		path + "?page=1": `{"page":1,"players":[{"id":"206249128286552064","username":"Ram","avatar":"","xp":100,"level":1}],"role_rewards":[{"rank":5,"role":{"id":"806249128286552064","color":255}}]}`,
+		path + "?page=2": `{"page":2,"players":[],"role_rewards":[]}`,
+	})
+	defer server.Close()
+
+	users, roleRewards, err := fetchImport(context.Background(), mee6Importer{baseURL: server.URL}, testImportGuildID)
+	if err != nil {
+		t.Errorf("Failed to import: %s\n", err)
+		return
+	}
+
+	if len(users) != 2 || users[0].Nickname != "Rem" || users[1].XP != 100 {
+		t.Errorf("Unexpected users %v\n", users)
+	}
+	if len(roleRewards) != 1 || roleRewards[0].Color != 255 || roleRewards[0].Level != 5 || !roleRewards[0].Persistent {
+		t.Errorf("Expected role rewards from the first page only, got %v\n", roleRewards)
+	}
+
+	p, err := mee6Importer{baseURL: server.URL}.FetchPage(context.Background(), testImportGuildID, 0)
+	if err != nil {
+		t.Errorf("Failed to fetch first page: %s\n", err)
+		return
+	}
+	if p.Settings == nil || p.Settings.XPMin == nil || *p.Settings.XPMin != 15 {
+		t.Errorf("Expected settings on the first page, got %v\n", p.Settings)
+	}
+
+}
+
+func TestFetchPages(t *testing.T) {
+
+	path := "/api/plugins/levels/leaderboard/" + testImportGuildID
+	server := fakeLeaderboard(t, "", map[string]string{
+		path + "?page=0": `{"players":[{"id":"196249128286552064","xp":300}]}`,
+		path + "?page=1": `{"players":[{"id":"206249128286552064","xp":100}]}`,
+		path + "?page=2": `{"players":[]}`,
+		path + "?page=3": `{"players":[]}`,
+	})
+	defer server.Close()
+
+	pages, err := fetchPages(context.Background(), mee6Importer{baseURL: server.URL}, testImportGuildID, 0, 4, func(int, error) {})
+	if err != nil {
+		t.Errorf("Failed to fetch pages: %s\n", err)
+		return
+	}
+	if len(pages) != 3 || !pages[2].Last {
+		t.Errorf("Expected pages to stop at the last one, got %d\n", len(pages))
+		return
+	}
+	if pages[0].Users[0].XP != 300 || pages[1].Users[0].XP != 100 {
+		t.Errorf("Pages are out of order: %v\n", pages)
+	}
+
+}
+
+func TestTatsuImporter(t *testing.T) {
+
+	path := "/v1/guilds/" + testImportGuildID + "/rankings/all"
+	var fullPage []string
+	for i := 0; i < tatsuPageSize; i++ {
+		fullPage = append(fullPage, fmt.Sprintf(`{"rank":%d,"user_id":"1962491282865%05d","score":%d}`, i+1, i, 1000-i))
+	}
+	server := fakeLeaderboard(t, "key", map[string]string{
+		path + "?offset=0":   `{"rankings":[` + strings.Join(fullPage, ",") + `]}`,
+		path + "?offset=100": `{"rankings":[{"rank":101,"user_id":"206249128286552064","score":5}]}`,
+	})
+	defer server.Close()
+
+	users, roleRewards, err := fetchImport(context.Background(), tatsuImporter{baseURL: server.URL, apiKey: "key"}, testImportGuildID)
+	if err != nil {
+		t.Errorf("Failed to import: %s\n", err)
+		return
+	}
+
+	if len(users) != tatsuPageSize+1 || users[tatsuPageSize].XP != 5 {
+		t.Errorf("Expected %d users ending with 5 xp, got %d\n", tatsuPageSize+1, len(users))
+	}
+	if len(roleRewards) != 0 {
+		t.Errorf("Expected no role rewards, got %v\n", roleRewards)
+	}
+
+}
+
+func TestAmariImporter(t *testing.T) {
+
+	server := fakeLeaderboard(t, "key", map[string]string{
+		"/api/v1/guild/leaderboard/" + testImportGuildID + "?page=1&limit=1000": `{"count":1,"data":[{"id":"196249128286552064","username":"Rem","exp":4675,"level":10}],"total_count":1}`,
+		"/api/v1/guild/rewards/" + testImportGuildID + "?page=1&limit=1000":     `{"count":1,"data":[{"roleID":"806249128286552064","level":10}]}`,
+	})
+	defer server.Close()
+
+	users, roleRewards, err := fetchImport(context.Background(), amariImporter{baseURL: server.URL, apiKey: "key"}, testImportGuildID)
+	if err != nil {
+		t.Errorf("Failed to import: %s\n", err)
+		return
+	}
+
+	if len(users) != 1 || users[0].XP != 4675 {
+		t.Errorf("Unexpected users %v\n", users)
+	}
+	if len(roleRewards) != 1 || roleRewards[0].RoleID != "806249128286552064" {
+		t.Errorf("Unexpected role rewards %v\n", roleRewards)
+	}
+
+}
+
+func TestArcaneImporter(t *testing.T) {
+
+	path := "/api/guilds/" + testImportGuildID + "/levels"
+	server := fakeLeaderboard(t, "key", map[string]string{
+		path + "?page=0&limit=100": `{"members":[{"id":"196249128286552064","username":"Rem","avatar":"abc","xp":300}],"roleRewards":[{"roleID":"806249128286552064","level":2}],"hasMore":true}`,
+		path + "?page=1&limit=100": `{"members":[{"id":"206249128286552064","username":"Ram","avatar":"","xp":100}],"roleRewards":[{"roleID":"806249128286552064","level":2}],"hasMore":false}`,
+	})
+	defer server.Close()
+
+	users, roleRewards, err := fetchImport(context.Background(), arcaneImporter{baseURL: server.URL, apiKey: "key"}, testImportGuildID)
+	if err != nil {
+		t.Errorf("Failed to import: %s\n", err)
+		return
+	}
+
+	if len(users) != 2 || users[0].Avatar != "abc" {
+		t.Errorf("Unexpected users %v\n", users)
+	}
+	if len(roleRewards) != 1 {
+		t.Errorf("Expected role rewards from the first page only, got %v\n", roleRewards)
+	}
+
+}
+
+func TestImporterErrors(t *testing.T) {
+
+	server := fakeLeaderboard(t, "key", map[string]string{})
+	defer server.Close()
+
+	_, _, err := fetchImport(context.Background(), tatsuImporter{baseURL: server.URL, apiKey: "wrong"}, testImportGuildID)
+	if err == nil || err.Error() != "TatsuAuthError" {
+		t.Errorf("Expected TatsuAuthError, got %v\n", err)
+	}
+
+	for source, newImporter := range importers {
+		if source == "MEE6" {
+			continue
+		}
+		if _, err := newImporter(""); err == nil {
+			t.Errorf("Expected %s to require an API key\n", source)
+		}
+	}
+
+}
+
+func TestDropInvalidRows(t *testing.T) {
+
+	page := importPage{
+		Users: []importedUser{
+			{UserID: "123456789012345678", Nickname: "fine", XP: 10},
+			{UserID: "not a snowflake", XP: 10},
+			{UserID: "223456789012345678", Nickname: strings.Repeat("n", 33), XP: 10},
+			{UserID: "323456789012345678", Avatar: strings.Repeat("a", 35), XP: -1},
+		},
+		RoleRewards: []importedRoleReward{
+			{RoleID: "423456789012345678", Level: 5},
+			{RoleID: "523456789012345678", Level: -1, Color: 0x1000000},
+		},
+		Last: true,
+	}
+
+	valid, rowErrors := page.dropInvalidRows()
+	if len(valid.Users) != 1 || valid.Users[0].UserID != "123456789012345678" {
+		t.Errorf("Expected only the valid user to be kept, got %v\n", valid.Users)
+	}
+	if len(valid.RoleRewards) != 1 || valid.RoleRewards[0].RoleID != "423456789012345678" {
+		t.Errorf("Expected only the valid role reward to be kept, got %v\n", valid.RoleRewards)
+	}
+	if !valid.Last {
+		t.Error("Expected the page to still be the last one")
+	}
+	if len(rowErrors) != 4 {
+		t.Errorf("Expected 4 row errors, got %v\n", rowErrors)
+	}
+
+}
@@ -6,10 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
+	"cloud.google.com/go/pubsub"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -19,26 +22,13 @@ type DBEntry []interface{}
 
 type DBEntries [][]interface{}
 
-type User struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Avatar   string `json:"avatar"`
-	Xp       int64  `json:"xp"`
-}
-
-type RoleReward struct {
-	Level int `json:"rank"`
-	Role  struct {
-		ID    string `json:"id"`
-		Color int    `json:"color"`
-	} `json:"role"`
-}
-
-type Mee6 struct {
-	Page        int          `json:"page"`
-	Users       []User       `json:"players"`
-	RoleRewards []RoleReward `json:"role_rewards"`
-}
+// Ledger sources, imports are recorded as "import:<source>".
+const (
+	ledgerSourceReset   = "reset"
+	ledgerSourceImport  = "import:"
+	ledgerSourceAdmin   = "admin"
+	ledgerSourceRestore = "restore"
+)
 
 func init() {
 	functions.HTTP("modify-levels", modifyLevels)
@@ -61,6 +51,8 @@ func corsHandler(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+var client *pubsub.Client
+
 var pool *pgxpool.Pool
 
 func createPool() (err error) {
@@ -79,6 +71,26 @@ type LevelParams struct {
 	CallerID  string `json:"callerID"`
 	Token     int64  `json:"token"`
 	Source    string `json:"source"`
+	// APIKey is needed by imports from bots that don't have public leaderboards.
+	APIKey string `json:"apiKey"`
+	// Amount and TargetID are used by the per-user operations, a transfer with no amount moves all xp.
+	Amount   int64  `json:"amount"`
+	TargetID string `json:"targetID"`
+	// Format is csv or json for exports and file imports, CSV exports also pick a Dataset.
+	Format  string `json:"format"`
+	Dataset string `json:"dataset"`
+	// Data is the uploaded file for imports from the file source.
+	Data string `json:"data"`
+	// Merge picks how imports treat existing xp, leaving it empty replaces the guild's levels.
+	Merge string `json:"merge"`
+	// DryRun makes imports report what they would change without writing anything.
+	DryRun bool `json:"dryRun"`
+	// JobID picks the import job for importStatus, the latest job is used when it is empty.
+	JobID int64 `json:"jobID"`
+	// SnapshotID is the snapshot to restore.
+	SnapshotID int64 `json:"snapshotID"`
+	// ConfirmationToken confirms a reset, replacing import or restore, see requireConfirmation.
+	ConfirmationToken string `json:"confirmationToken"`
 }
 
 func modifyLevels(writer http.ResponseWriter, request *http.Request) {
@@ -100,8 +112,6 @@ func modifyLevels(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	fmt.Println(params)
-
 	err = confirmPermission(params.GuildID, params.CallerID, params.Token)
 	if err != nil {
 		writer.WriteHeader(http.StatusUnauthorized)
@@ -109,16 +119,134 @@ func modifyLevels(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	fmt.Println(params)
-	fmt.Println(params.Operation)
+	// Params can hold uploaded files, API keys and confirmation tokens, so only say what is being done.
+	fmt.Println("Operation", params.Operation, "on guild", params.GuildID)
 
 	switch params.Operation {
 	case "reset":
-		err = resetLevels(params.GuildID)
+		if !requireConfirmation(writer, request, params) {
+			return
+		}
+		err = resetLevels(params.GuildID, params.CallerID)
 		break
 	case "import":
-		err = importLevels(params.GuildID, params.Source)
+		if err = validateMerge(params.Merge); err == nil {
+			err = validateImportSource(params)
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+		if !requireConfirmation(writer, request, params) {
+			return
+		}
+		if params.Source != "file" {
+			var jobID int64
+			jobID, err = enqueueImport(request.Context(), params)
+			if err != nil {
+				break
+			}
+			writer.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(writer).Encode(importJobMessage{JobID: jobID})
+			return
+		}
+		var preview ImportPreview
+		preview, err = importFileLevels(request.Context(), params)
+		var fileErr *ImportFileError
+		if errors.As(err, &fileErr) {
+			writer.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(writer).Encode(fileErr)
+			return
+		}
+		if err == nil && params.DryRun {
+			writer.WriteHeader(http.StatusOK)
+			json.NewEncoder(writer).Encode(preview)
+			return
+		}
+		break
+	case "importStatus":
+		var job ImportJob
+		job, err = getImportJob(request.Context(), params.GuildID, params.JobID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			writer.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(writer, "Import job not found")
+			return
+		}
+		if err != nil {
+			break
+		}
+		writer.WriteHeader(http.StatusOK)
+		json.NewEncoder(writer).Encode(job)
+		return
+	case "listSnapshots":
+		var snapshots []Snapshot
+		snapshots, err = listSnapshots(request.Context(), params.GuildID)
+		if err != nil {
+			break
+		}
+		writer.WriteHeader(http.StatusOK)
+		json.NewEncoder(writer).Encode(snapshots)
+		return
+	case "restore":
+		if params.SnapshotID <= 0 {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", errors.New("Invalid snapshot"))
+			return
+		}
+		if !requireConfirmation(writer, request, params) {
+			return
+		}
+		var updated []xpUpdate
+		updated, err = restoreSnapshot(request.Context(), params.GuildID, params.CallerID, params.SnapshotID)
+		if errors.Is(err, errSnapshotNotFound) {
+			writer.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(writer, err)
+			return
+		}
+		if err != nil {
+			break
+		}
+		err = pushToRemraku(params.GuildID, updated, request)
+		break
+	case "set", "add", "remove", "transfer":
+		if err = validateAdjustment(params); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+		var updated []xpUpdate
+		updated, err = adjustLevels(request.Context(), params)
+		if errors.Is(err, errXPOverflow) || errors.Is(err, errNoXP) || errors.Is(err, errNotEnoughXP) {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+		if err != nil {
+			break
+		}
+		err = pushToRemraku(params.GuildID, updated, request)
 		break
+	case "export":
+		if err = validateExport(params); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+		var started bool
+		started, err = exportLevels(request.Context(), writer, params)
+		if err != nil && !started {
+			break
+		}
+		// Once the response is streaming, a failure can only be logged.
+		if err != nil {
+			fmt.Println("Export failed:", err)
+		}
+		return
+	default:
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid operation")
+		return
 	}
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -148,111 +276,280 @@ func confirmPermission(guildID string, callerID string, token int64) (err error)
 	return
 }
 
-func resetLevels(guildID string) (err error) {
-	_, err = pool.Exec(context.Background(), "DELETE FROM guildxp WHERE guildID = $1", guildID)
-	_, err = pool.Exec(context.Background(), "DELETE FROM rolerewards WHERE guildID = $1", guildID)
-	return
-}
+func resetLevels(guildID string, callerID string) (err error) {
 
-func importLevels(guildID string, source string) (err error) {
-	err = resetLevels(guildID)
+	ctx := context.Background()
+	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return
 	}
+	defer tx.Rollback(ctx)
 
-	switch source {
-	case "MEE6":
-		err = importMEE6(guildID)
-		break
-	default:
-		err = errors.New("Invalid source")
-		break
+	_, err = takeSnapshot(ctx, tx, guildID, callerID, ledgerSourceReset)
+	if err != nil {
+		return
 	}
+	err = pruneSnapshots(ctx, tx, guildID)
+	if err != nil {
+		return
+	}
+
+	err = resetGuild(ctx, tx, guildID, callerID)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
 	return
 
 }
 
-func handleMee6Page(guildID string, curPage int, m *Mee6) (lastPage bool, err error) {
-	fmt.Println("Handling page", curPage)
-	resp, err := http.Get(fmt.Sprintf("https://mee6.xyz/api/plugins/levels/leaderboard/%s?page=%d", guildID, curPage))
+func resetGuild(ctx context.Context, tx pgx.Tx, guildID string, callerID string) (err error) {
+
+	_, err = tx.Exec(ctx, "INSERT INTO xpledger (guildID, userID, delta, total, source, actorID) SELECT guildID, userID, -xp, 0, $2, $3 FROM guildxp WHERE guildID = $1 AND xp <> 0", guildID, ledgerSourceReset, callerID)
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusUnauthorized {
-		err = errors.New("MEE6AuthError")
+	_, err = tx.Exec(ctx, "DELETE FROM guildxp WHERE guildID = $1", guildID)
+	if err != nil {
 		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		err = errors.New("Failed to get page")
+	_, err = tx.Exec(ctx, "DELETE FROM rolerewards WHERE guildID = $1", guildID)
+	return
+
+}
+
+// importFileLevels imports an uploaded file right away, other sources go through import jobs.
+func importFileLevels(ctx context.Context, params LevelParams) (preview ImportPreview, err error) {
+
+	file, err := parseImportFile(params.Format, params.Data)
+	if err != nil {
 		return
 	}
-	err = json.NewDecoder(resp.Body).Decode(m)
+	users, roleRewards := file.entries()
+
+	preview, err = writeImport(ctx, params.GuildID, params.CallerID, params.Source, params.Merge, params.DryRun, users, roleRewards)
+	return
+
+}
+
+type importedUser struct {
+	UserID   string
+	Nickname string
+	Avatar   string
+	XP       int64
+}
+
+type importedRoleReward struct {
+	RoleID        string
+	Level         int
+	Color         int
+	Persistent    bool
+	ExpiresAfter  *int
+	TopN          *int
+	TopWindowDays *int
+}
+
+func isSnowflake(s string) bool {
+	if len(s) < 17 || len(s) > 20 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+// envInt reads a positive number from the environment, fallback is used when it is missing or invalid.
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func validateAdjustment(params LevelParams) error {
+	if !isSnowflake(params.UserID) {
+		return errors.New("Invalid user")
+	}
+	if params.Amount < 0 {
+		return errors.New("Amount cannot be negative")
+	}
+	switch params.Operation {
+	case "add", "remove":
+		if params.Amount == 0 {
+			return errors.New("Amount must be positive")
+		}
+	case "transfer":
+		if !isSnowflake(params.TargetID) {
+			return errors.New("Invalid target user")
+		}
+		if params.TargetID == params.UserID {
+			return errors.New("Cannot transfer xp to the same user")
+		}
+	}
+	return nil
+}
+
+var (
+	errXPOverflow  = errors.New("XP would go past the most a member can have")
+	errNoXP        = errors.New("User has no xp")
+	errNotEnoughXP = errors.New("User doesn't have enough xp")
+)
+
+// addXP adds amount to xp, refusing to wrap around past the int64 limit.
+func addXP(xp int64, amount int64) (int64, error) {
+	if xp > math.MaxInt64-amount {
+		return 0, errXPOverflow
+	}
+	return xp + amount, nil
+}
+
+type xpUpdate struct {
+	UserID string `json:"userID"`
+	XP     int64  `json:"xp"`
+}
+
+// lockXP reads a member's xp and holds the row until the transaction ends.
+func lockXP(ctx context.Context, tx pgx.Tx, guildID string, userID string) (xp int64, exists bool, err error) {
+	err = tx.QueryRow(ctx, "SELECT xp FROM guildxp WHERE guildID = $1 AND userID = $2 FOR UPDATE", guildID, userID).Scan(&xp)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		return
+	}
+	exists = err == nil
+	return
+}
+
+func writeXP(ctx context.Context, tx pgx.Tx, guildID string, userID string, oldXP int64, newXP int64, callerID string) (err error) {
+
+	// Members without a row have never talked, the bot fills in their nickname and avatar later.
+	_, err = tx.Exec(ctx, "INSERT INTO guildxp (guildID, userID, nickname, avatar, xp) VALUES ($1, $2, '', '', $3) ON CONFLICT (guildID, userID) DO UPDATE SET xp = $3", guildID, userID, newXP)
 	if err != nil {
 		return
 	}
-	if len(m.Users) == 0 {
-		lastPage = true
+
+	if newXP == oldXP {
+		return
 	}
-	fmt.Println(m)
+	_, err = tx.Exec(ctx, "INSERT INTO xpledger (guildID, userID, delta, total, source, actorID) VALUES ($1, $2, $3, $4, $5, $6)", guildID, userID, newXP-oldXP, newXP, ledgerSourceAdmin, callerID)
 	return
+
 }
 
-func importMEE6(guildID string) (err error) {
-	lastPage := false
-	curPage := 0
-	users := make(DBEntries, 0)
-	roleRewards := make([]RoleReward, 0)
-	for !lastPage {
-		var m Mee6
-		lastPage, err = handleMee6Page(guildID, curPage, &m)
+func adjustLevels(ctx context.Context, params LevelParams) (updated []xpUpdate, err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock both sides of a transfer in a fixed order so opposing transfers can't deadlock.
+	if params.Operation == "transfer" && params.TargetID < params.UserID {
+		_, _, err = lockXP(ctx, tx, params.GuildID, params.TargetID)
 		if err != nil {
-			lastPage, err = handleMee6Page(guildID, curPage, &m)
-			if err != nil {
-				return
-			}
+			return
 		}
-		for _, user := range m.Users {
-			fmt.Println(user)
-			users = append(users, DBEntry{
-				guildID,
-				user.ID,
-				user.Username,
-				user.Avatar,
-				user.Xp,
-			})
-		}
-		if curPage == 0 {
-			roleRewards = m.RoleRewards
-		}
-		curPage++
-	}
-	fmt.Println(users)
-	_, err = pool.CopyFrom(
-		context.Background(),
-		pgx.Identifier{"guildxp"},
-		[]string{"guildid", "userid", "nickname", "avatar", "xp"},
-		pgx.CopyFromRows(users),
-	)
+	}
+
+	xp, exists, err := lockXP(ctx, tx, params.GuildID, params.UserID)
 	if err != nil {
 		return
 	}
 
-	roleRewardInsert := make(DBEntries, 0)
-	for _, r := range roleRewards {
-		roleRewardInsert = append(roleRewardInsert, DBEntry{
-			guildID,
-			r.Role.ID,
-			r.Level,
-			r.Role.Color,
-		})
-	}
-	_, err = pool.CopyFrom(
-		context.Background(),
-		pgx.Identifier{"rolerewards"},
-		[]string{"guildid", "roleid", "level", "color"},
-		pgx.CopyFromRows(roleRewardInsert),
-	)
+	newXP := xp
+	switch params.Operation {
+	case "set":
+		newXP = params.Amount
+		break
+	case "add":
+		newXP, err = addXP(xp, params.Amount)
+		if err != nil {
+			return
+		}
+		break
+	case "remove":
+		if !exists {
+			err = errNoXP
+			return
+		}
+		newXP = xp - params.Amount
+		if newXP < 0 {
+			newXP = 0
+		}
+		break
+	case "transfer":
+		if !exists {
+			err = errNoXP
+			return
+		}
+		amount := params.Amount
+		if amount == 0 {
+			amount = xp
+		}
+		if amount > xp {
+			err = fmt.Errorf("%w, they only have %d", errNotEnoughXP, xp)
+			return
+		}
+		newXP = xp - amount
+
+		var targetXP int64
+		targetXP, _, err = lockXP(ctx, tx, params.GuildID, params.TargetID)
+		if err != nil {
+			return
+		}
+		var newTargetXP int64
+		newTargetXP, err = addXP(targetXP, amount)
+		if err != nil {
+			return
+		}
+		err = writeXP(ctx, tx, params.GuildID, params.TargetID, targetXP, newTargetXP, params.CallerID)
+		if err != nil {
+			return
+		}
+		updated = append(updated, xpUpdate{UserID: params.TargetID, XP: newTargetXP})
+		break
+	}
+
+	err = writeXP(ctx, tx, params.GuildID, params.UserID, xp, newXP, params.CallerID)
+	if err != nil {
+		return
+	}
+	updated = append(updated, xpUpdate{UserID: params.UserID, XP: newXP})
+
+	err = tx.Commit(ctx)
+	return
+
+}
+
+type levelsMessage struct {
+	Type    string     `json:"type"`
+	GuildID string     `json:"guildID"`
+	Users   []xpUpdate `json:"users"`
+}
+
+// pushToRemraku tells the bot which members changed so it can re-evaluate their role rewards.
+func pushToRemraku(guildID string, updated []xpUpdate, request *http.Request) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(levelsMessage{
+		Type:    "levels",
+		GuildID: guildID,
+		Users:   updated,
+	})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(request.Context(), m).Get(request.Context())
 
 	return
 
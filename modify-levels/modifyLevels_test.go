@@ -1,9 +1,50 @@
 package remmodifylevels
 
 import (
+	"math"
 	"testing"
 )
 
 func TestModifyLevels(t *testing.T) {
 
 }
+
+func TestValidateAdjustment(t *testing.T) {
+
+	valid := []LevelParams{
+		{Operation: "set", UserID: "196249128286552064", Amount: 0},
+		{Operation: "add", UserID: "196249128286552064", Amount: 100},
+		{Operation: "remove", UserID: "196249128286552064", Amount: 100},
+		{Operation: "transfer", UserID: "196249128286552064", TargetID: "267794154459889664"},
+	}
+
+	for _, params := range valid {
+		if err := validateAdjustment(params); err != nil {
+			t.Errorf("Expected %v to be valid, got %s\n", params, err)
+		}
+	}
+
+	invalid := []LevelParams{
+		{Operation: "set", UserID: "", Amount: 10},
+		{Operation: "set", UserID: "not a snowflake", Amount: 10},
+		{Operation: "set", UserID: "196249128286552064", Amount: -10},
+		{Operation: "add", UserID: "196249128286552064", Amount: 0},
+		{Operation: "remove", UserID: "196249128286552064", Amount: 0},
+		{Operation: "transfer", UserID: "196249128286552064", TargetID: ""},
+		{Operation: "transfer", UserID: "196249128286552064", TargetID: "196249128286552064"},
+	}
+
+	for _, params := range invalid {
+		if err := validateAdjustment(params); err == nil {
+			t.Errorf("Expected %v to be invalid\n", params)
+		}
+	}
+
+	if xp, err := addXP(100, 50); err != nil || xp != 150 {
+		t.Errorf("Expected 150, got %d, %v\n", xp, err)
+	}
+	if _, err := addXP(math.MaxInt64-10, 11); err != errXPOverflow {
+		t.Errorf("Expected %s, got %v\n", errXPOverflow, err)
+	}
+
+}
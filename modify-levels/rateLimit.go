@@ -0,0 +1,20 @@
+package remmodifylevels
+
+import (
+	"time"
+
+	"github.com/yayuyokitano/rem-next/ratelimit"
+)
+
+const (
+	defaultImportRateLimit   = 2
+	defaultImportConcurrency = 4
+)
+
+// importClient is shared by every importer, so the rate limit holds however many pages are being fetched at once.
+var importClient = ratelimit.NewClient(envInt("IMPORT_RATE_LIMIT", defaultImportRateLimit), 30*time.Second)
+
+// importConcurrency is how many pages an import job fetches at the same time.
+func importConcurrency() int {
+	return envInt("IMPORT_CONCURRENCY", defaultImportConcurrency)
+}
@@ -0,0 +1,159 @@
+package remmodifylevels
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const defaultSnapshotRetention = 10
+
+var errSnapshotNotFound = errors.New("Snapshot not found")
+
+type Snapshot struct {
+	ID          int64     `json:"id"`
+	Reason      string    `json:"reason"`
+	ActorID     string    `json:"actorID"`
+	Users       int       `json:"users"`
+	RoleRewards int       `json:"roleRewards"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// takeSnapshot copies the guild's levels in the same transaction as the change it is taken for,
+// so a snapshot always matches what the guild looked like right before it. Callers prune once they are done reading snapshots.
+func takeSnapshot(ctx context.Context, tx pgx.Tx, guildID string, callerID string, reason string) (snapshotID int64, err error) {
+
+	err = tx.QueryRow(ctx, "INSERT INTO levelsnapshots (guildID, reason, actorID) VALUES ($1, $2, $3) RETURNING id", guildID, reason, callerID).Scan(&snapshotID)
+	if err != nil {
+		return
+	}
+
+	users, err := tx.Exec(ctx, "INSERT INTO levelsnapshotxp (snapshotID, userID, nickname, avatar, xp) SELECT $1, userID, nickname, avatar, xp FROM guildxp WHERE guildID = $2", snapshotID, guildID)
+	if err != nil {
+		return
+	}
+	roleRewards, err := tx.Exec(ctx, "INSERT INTO levelsnapshotrolerewards (snapshotID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays) SELECT $1, roleID, level, color, persistent, expiresAfter, topN, topWindowDays FROM rolerewards WHERE guildID = $2", snapshotID, guildID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "UPDATE levelsnapshots SET users = $2, roleRewards = $3 WHERE id = $1", snapshotID, users.RowsAffected(), roleRewards.RowsAffected())
+	return
+
+}
+
+// pruneSnapshots keeps only the newest snapshots of the guild.
+func pruneSnapshots(ctx context.Context, tx pgx.Tx, guildID string) (err error) {
+
+	_, err = tx.Exec(ctx, "DELETE FROM levelsnapshots WHERE guildID = $1 AND id NOT IN (SELECT id FROM levelsnapshots WHERE guildID = $1 ORDER BY id DESC LIMIT $2)",
+		guildID, envInt("SNAPSHOT_RETENTION", defaultSnapshotRetention))
+	return
+
+}
+
+func listSnapshots(ctx context.Context, guildID string) (snapshots []Snapshot, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT id, reason, COALESCE(actorID, ''), users, roleRewards, createdAt FROM levelsnapshots WHERE guildID = $1 ORDER BY id DESC", guildID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	snapshots = make([]Snapshot, 0)
+	for rows.Next() {
+		var s Snapshot
+		err = rows.Scan(&s.ID, &s.Reason, &s.ActorID, &s.Users, &s.RoleRewards, &s.CreatedAt)
+		if err != nil {
+			return
+		}
+		snapshots = append(snapshots, s)
+	}
+	err = rows.Err()
+	return
+
+}
+
+// restoreSnapshot puts the guild's levels back the way they were in the snapshot and returns the users whose xp changed.
+// The current levels are snapshotted first, so a restore can be undone as well.
+func restoreSnapshot(ctx context.Context, guildID string, callerID string, snapshotID int64) (updated []xpUpdate, err error) {
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM levelsnapshots WHERE id = $1 AND guildID = $2)", snapshotID, guildID).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = errSnapshotNotFound
+		return
+	}
+
+	_, err = tx.Exec(ctx, "SELECT 1 FROM guildxp WHERE guildID = $1 FOR UPDATE", guildID)
+	if err != nil {
+		return
+	}
+
+	_, err = takeSnapshot(ctx, tx, guildID, callerID, ledgerSourceRestore)
+	if err != nil {
+		return
+	}
+
+	// Only users whose xp actually changes get a ledger row, with the difference as the delta.
+	rows, err := tx.Query(ctx, `INSERT INTO xpledger (guildID, userID, delta, total, source, actorID)
+		SELECT $1, COALESCE(s.userID, g.userID), COALESCE(s.xp, 0) - COALESCE(g.xp, 0), COALESCE(s.xp, 0), $3, $4
+		FROM (SELECT userID, xp FROM levelsnapshotxp WHERE snapshotID = $2) s
+		FULL JOIN (SELECT userID, xp FROM guildxp WHERE guildID = $1) g ON g.userID = s.userID
+		WHERE COALESCE(s.xp, 0) <> COALESCE(g.xp, 0)
+		RETURNING userID, total`, guildID, snapshotID, ledgerSourceRestore, callerID)
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var u xpUpdate
+		err = rows.Scan(&u.UserID, &u.XP)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		updated = append(updated, u)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM guildxp WHERE guildID = $1", guildID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO guildxp (guildID, userID, nickname, avatar, xp) SELECT $1, userID, nickname, avatar, xp FROM levelsnapshotxp WHERE snapshotID = $2", guildID, snapshotID)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM rolerewards WHERE guildID = $1", guildID)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(ctx, "INSERT INTO rolerewards (guildID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays) SELECT $1, roleID, level, color, persistent, expiresAfter, topN, topWindowDays FROM levelsnapshotrolerewards WHERE snapshotID = $2", guildID, snapshotID)
+	if err != nil {
+		return
+	}
+
+	// Pruning waits until the copy is done, the snapshot being restored may well be the oldest one.
+	err = pruneSnapshots(ctx, tx, guildID)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	return
+
+}
@@ -0,0 +1,76 @@
+package remmodifylevels
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+const (
+	testSnapshotGuildID = "106249128286552066"
+	testSnapshotUserID  = "106249128286552067"
+)
+
+func TestRestoreOldestSnapshot(t *testing.T) {
+
+	if os.Getenv("DATABASE_PRIVATE_URL") == "" {
+		t.Skip("DATABASE_PRIVATE_URL not set")
+	}
+	if err := createPool(); err != nil {
+		t.Errorf("Failed to create pool: %s\n", err)
+		return
+	}
+
+	os.Setenv("SNAPSHOT_RETENTION", "2")
+	defer os.Unsetenv("SNAPSHOT_RETENTION")
+
+	ctx := context.Background()
+	cleanup := func() {
+		pool.Exec(ctx, "DELETE FROM guildxp WHERE guildID = $1", testSnapshotGuildID)
+		pool.Exec(ctx, "DELETE FROM rolerewards WHERE guildID = $1", testSnapshotGuildID)
+		pool.Exec(ctx, "DELETE FROM levelsnapshots WHERE guildID = $1", testSnapshotGuildID)
+	}
+	cleanup()
+	defer cleanup()
+
+	// Each reset snapshots the xp it wipes, which fills the retention with the two values.
+	for _, xp := range []int64{100, 200} {
+		_, err := pool.Exec(ctx, "INSERT INTO guildxp (guildID, userID, nickname, avatar, xp) VALUES ($1, $2, '', '', $3)", testSnapshotGuildID, testSnapshotUserID, xp)
+		if err != nil {
+			t.Errorf("Failed to insert xp: %s\n", err)
+			return
+		}
+		if err := resetLevels(testSnapshotGuildID, testSnapshotUserID); err != nil {
+			t.Errorf("Failed to reset levels: %s\n", err)
+			return
+		}
+	}
+
+	snapshots, err := listSnapshots(ctx, testSnapshotGuildID)
+	if err != nil || len(snapshots) != 2 {
+		t.Errorf("Expected 2 snapshots, got %v: %v\n", snapshots, err)
+		return
+	}
+	oldest := snapshots[len(snapshots)-1]
+
+	updated, err := restoreSnapshot(ctx, testSnapshotGuildID, testSnapshotUserID, oldest.ID)
+	if err != nil {
+		t.Errorf("Failed to restore the oldest snapshot: %s\n", err)
+		return
+	}
+	if len(updated) != 1 || updated[0].UserID != testSnapshotUserID || updated[0].XP != 100 {
+		t.Errorf("Expected the user to be reported back at 100 xp, got %v\n", updated)
+	}
+
+	var xp int64
+	err = pool.QueryRow(ctx, "SELECT xp FROM guildxp WHERE guildID = $1 AND userID = $2", testSnapshotGuildID, testSnapshotUserID).Scan(&xp)
+	if err != nil || xp != 100 {
+		t.Errorf("Expected 100 xp after restoring, got %d: %v\n", xp, err)
+	}
+
+	snapshots, err = listSnapshots(ctx, testSnapshotGuildID)
+	if err != nil || len(snapshots) != 2 {
+		t.Errorf("Expected the restore to keep retention at 2 snapshots, got %v: %v\n", snapshots, err)
+	}
+
+}
@@ -0,0 +1,146 @@
+// Package ratelimit keeps functions that call other APIs within the rate limits of those APIs.
+//
+// A Limiter spaces requests out evenly, and a Transport sends requests through one,
+// retrying the ones answered with 429 and waiting for the bucket to refill once a
+// response says it is used up. Share one client per API, so the limit holds however
+// many requests are in flight.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxRetries is how often a request answered with 429 is retried, so nothing hangs on an API that never lets up.
+	MaxRetries = 5
+	// DefaultRetryAfter is used when a response says to wait without a usable header saying how long.
+	DefaultRetryAfter = 5 * time.Second
+)
+
+var ErrTooManyRetries = errors.New("Rate limited too many times")
+
+// EnvInt reads a positive number from the environment, fallback is used when it is missing or invalid.
+func EnvInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Limiter spaces requests out evenly, perSecond at most.
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func NewLimiter(perSecond int) *Limiter {
+	return &Limiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// Wait blocks until the caller may send its request.
+func (l *Limiter) Wait(ctx context.Context) error {
+
+	l.mu.Lock()
+	at := l.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+
+}
+
+// Pause holds back every request until d has passed.
+func (l *Limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	if until := time.Now().Add(d); until.After(l.next) {
+		l.next = until
+	}
+	l.mu.Unlock()
+}
+
+// Transport applies the limiter to every request, retries the ones answered with 429,
+// and waits for the bucket to refill once X-RateLimit-Remaining says it is used up.
+// Only bodiless requests are sent through it, so retrying them as-is is safe.
+type Transport struct {
+	Limiter *Limiter
+	Base    http.RoundTripper
+}
+
+// NewClient returns a client that sends every request through one limiter of perSecond.
+func NewClient(perSecond int, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &Transport{
+			Limiter: NewLimiter(perSecond),
+			Base:    http.DefaultTransport,
+		},
+	}
+}
+
+func (t *Transport) RoundTrip(request *http.Request) (resp *http.Response, err error) {
+
+	for attempt := 0; ; attempt++ {
+		err = t.Limiter.Wait(request.Context())
+		if err != nil {
+			return
+		}
+
+		resp, err = t.Base.RoundTrip(request)
+		if err != nil {
+			return
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+				t.Limiter.Pause(ResetAfter(resp))
+			}
+			return
+		}
+		resp.Body.Close()
+
+		if attempt >= MaxRetries {
+			err = ErrTooManyRetries
+			return
+		}
+		t.Limiter.Pause(RetryAfter(resp))
+	}
+
+}
+
+// RetryAfter reads Retry-After, which is either a number of seconds or a date.
+func RetryAfter(resp *http.Response) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(header, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return DefaultRetryAfter
+}
+
+// ResetAfter reads X-RateLimit-Reset-After, how long until the bucket for the route refills.
+func ResetAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Reset-After"), 64)
+	if err != nil || seconds < 0 {
+		return DefaultRetryAfter
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
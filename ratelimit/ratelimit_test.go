@@ -0,0 +1,107 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+
+	limiter := NewLimiter(20)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Errorf("Failed to wait: %s\n", err)
+			return
+		}
+	}
+
+	// The first request goes out right away, the other four are spaced 50ms apart.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected at least 200ms for 5 requests, took %s\n", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	limiter.Pause(time.Hour)
+	if err := limiter.Wait(ctx); err == nil {
+		t.Errorf("Expected cancelled wait to fail\n")
+	}
+
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if requests < 3 {
+			writer.Header().Set("Retry-After", "0.1")
+			writer.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(writer, "ok")
+	}))
+	defer server.Close()
+
+	client := NewClient(1000, 30*time.Second)
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Errorf("Failed to get: %s\n", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || requests != 3 {
+		t.Errorf("Expected success on the third request, got %d after %d\n", resp.StatusCode, requests)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected to wait out Retry-After twice, took %s\n", elapsed)
+	}
+
+	requests = -100
+	resp, err = client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrTooManyRetries) {
+		t.Errorf("Expected to give up after %d retries, got %v\n", MaxRetries, err)
+	}
+
+}
+
+func TestBucketReset(t *testing.T) {
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		requests++
+		if requests == 1 {
+			writer.Header().Set("X-RateLimit-Remaining", "0")
+			writer.Header().Set("X-RateLimit-Reset-After", "0.2")
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client := NewClient(1000, 30*time.Second)
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Errorf("Failed to get: %s\n", err)
+			return
+		}
+		resp.Body.Close()
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected to wait for the bucket to reset, took %s\n", elapsed)
+	}
+
+}
@@ -0,0 +1,338 @@
+package remrolestacking
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Stacking modes, matching the check on guilds.roleStacking.
+const (
+	// ModeCumulative keeps every role reward a member has reached.
+	ModeCumulative = "cumulative"
+	// ModeHighest keeps only the reward for the highest level reached.
+	ModeHighest = "highest"
+	// ModeKeepLast keeps the rewards for the Keep highest levels reached.
+	ModeKeepLast = "keepLast"
+)
+
+const maxKeep = 25
+
+func init() {
+	functions.HTTP("role-stacking", roleStacking)
+}
+
+var client *pubsub.Client
+
+var pool *pgxpool.Pool
+
+func createPool() (err error) {
+	if pool == nil {
+		ctx := context.Background()
+
+		pool, err = pgxpool.Connect(ctx, os.Getenv("DATABASE_PRIVATE_URL"))
+	}
+	return
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func corsHandler(writer http.ResponseWriter, request *http.Request) {
+	allowed := strings.Split(os.Getenv("ALLOWED_ORIGINS"), "||")
+	origin := request.Header.Get("Origin")
+	if contains(allowed, origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+type Stacking struct {
+	Mode string `json:"mode"`
+	// Keep is how many rewards ModeKeepLast keeps, it is 1 for the other modes.
+	Keep int `json:"keep"`
+	// CumulativeRoles mirrors guilds.cumulativeRoles, true only for ModeCumulative.
+	CumulativeRoles bool `json:"cumulativeRoles"`
+}
+
+// Matches the column defaults in guilds.
+var defaultStacking = Stacking{Mode: ModeHighest, Keep: 1}
+
+// Params is the PATCH body, fields left out of the request keep their current value.
+type Params struct {
+	GuildID string  `json:"guildID"`
+	UserID  string  `json:"userID"`
+	Token   int64   `json:"token"`
+	Mode    *string `json:"mode"`
+	Keep    *int    `json:"keep"`
+	// CumulativeRoles toggles between ModeCumulative and ModeHighest, for clients that only know the flag.
+	CumulativeRoles *bool `json:"cumulativeRoles"`
+}
+
+func roleStacking(writer http.ResponseWriter, request *http.Request) {
+
+	corsHandler(writer, request)
+
+	switch request.Method {
+	case "GET":
+		getStacking(writer, request)
+		break
+	case "PATCH":
+		patchStacking(writer, request)
+		break
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(writer, "Method not allowed")
+		break
+	}
+
+}
+
+func getStacking(writer http.ResponseWriter, request *http.Request) {
+
+	urlParams := request.URL.Query()
+	guildID := urlParams.Get("guildID")
+	userID := urlParams.Get("userID")
+	token, err := strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil || guildID == "" || userID == "" || token == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(guildID, userID, token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	current, err := fetchStacking(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role stacking: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(current)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func patchStacking(writer http.ResponseWriter, request *http.Request) {
+
+	var params Params
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	if params.GuildID == "" || params.Token == 0 || params.UserID == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	current, err := fetchStacking(request.Context(), params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role stacking: ", err)
+		return
+	}
+
+	updated, err := params.apply(current)
+	if err == nil {
+		err = updated.validate()
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid role stacking: ", err)
+		return
+	}
+
+	err = pushToDB(params.GuildID, updated, request)
+	if errors.Is(err, errGuildNotSetUp) {
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(writer, err)
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	if err := pushToRemraku(params.GuildID, updated, request); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(updated)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func (params Params) apply(s Stacking) (Stacking, error) {
+	if params.CumulativeRoles != nil {
+		if params.Mode != nil {
+			return s, errors.New("Set either mode or cumulativeRoles, not both")
+		}
+		s.Mode = ModeHighest
+		if *params.CumulativeRoles {
+			s.Mode = ModeCumulative
+		}
+	}
+	if params.Mode != nil {
+		s.Mode = *params.Mode
+	}
+	if params.Keep != nil {
+		s.Keep = *params.Keep
+	}
+	// Keep only means something for ModeKeepLast.
+	if s.Mode != ModeKeepLast {
+		s.Keep = 1
+	}
+	s.CumulativeRoles = s.Mode == ModeCumulative
+	return s, nil
+}
+
+func (s Stacking) validate() error {
+	switch s.Mode {
+	case ModeCumulative, ModeHighest:
+		break
+	case ModeKeepLast:
+		if s.Keep < 1 || s.Keep > maxKeep {
+			return fmt.Errorf("Keep must be between 1 and %d", maxKeep)
+		}
+		break
+	default:
+		return fmt.Errorf("Invalid mode %q", s.Mode)
+	}
+	return nil
+}
+
+func confirmPermission(guildID string, callerID string, token int64) (err error) {
+
+	client := &http.Client{}
+	resp, err := client.Post(os.Getenv("GCP_BASE_URI")+"/confirm-permission", "application/json", strings.NewReader(fmt.Sprintf(`{"guildID":"%s","userID":"%s","token":%d}`, guildID, callerID, token)))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Invalid token or user, or insufficient guild permissions: " + string(rawBody))
+		return
+	}
+	return
+}
+
+func fetchStacking(ctx context.Context, guildID string) (s Stacking, err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	err = pool.QueryRow(ctx, "SELECT roleStacking, roleStackingKeep, cumulativeRoles FROM guilds WHERE guildID = $1", guildID).Scan(&s.Mode, &s.Keep, &s.CumulativeRoles)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s = defaultStacking
+		err = nil
+	}
+	return
+
+}
+
+var errGuildNotSetUp = errors.New("Rem is not set up on this guild")
+
+// pushToDB only updates guilds Rem is already set up on, a guilds row is what marks Rem as a member.
+func pushToDB(guildID string, s Stacking, request *http.Request) (err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	tag, err := pool.Exec(request.Context(), "UPDATE guilds SET roleStacking = $2, roleStackingKeep = $3, cumulativeRoles = $4 WHERE guildID = $1",
+		guildID, s.Mode, s.Keep, s.CumulativeRoles)
+	if err != nil {
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		err = errGuildNotSetUp
+	}
+	return
+
+}
+
+type stackingMessage struct {
+	Type     string   `json:"type"`
+	GuildID  string   `json:"guildID"`
+	Stacking Stacking `json:"stacking"`
+}
+
+func pushToRemraku(guildID string, s Stacking, request *http.Request) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(stackingMessage{
+		Type:     "rolestacking",
+		GuildID:  guildID,
+		Stacking: s,
+	})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(request.Context(), m).Get(request.Context())
+
+	return
+
+}
@@ -0,0 +1,50 @@
+package remrolestacking
+
+import (
+	"testing"
+)
+
+func TestApplyStacking(t *testing.T) {
+
+	keepLast := ModeKeepLast
+	keep := 3
+	s, err := Params{Mode: &keepLast, Keep: &keep}.apply(defaultStacking)
+	if err != nil || s.validate() != nil {
+		t.Errorf("Expected keepLast to be valid, got %v %v\n", err, s.validate())
+	}
+	if s.Mode != ModeKeepLast || s.Keep != 3 || s.CumulativeRoles {
+		t.Errorf("Expected keepLast 3, got %+v\n", s)
+	}
+
+	cumulative := true
+	s, err = Params{CumulativeRoles: &cumulative}.apply(s)
+	if err != nil || s.Mode != ModeCumulative || s.Keep != 1 || !s.CumulativeRoles {
+		t.Errorf("Expected cumulativeRoles to switch to cumulative mode, got %+v %v\n", s, err)
+	}
+
+	cumulative = false
+	s, err = Params{CumulativeRoles: &cumulative}.apply(s)
+	if err != nil || s.Mode != ModeHighest || s.CumulativeRoles {
+		t.Errorf("Expected turning cumulativeRoles off to switch to highest, got %+v %v\n", s, err)
+	}
+
+	if _, err = (Params{Mode: &keepLast, CumulativeRoles: &cumulative}).apply(s); err == nil {
+		t.Errorf("Expected error when setting both mode and cumulativeRoles\n")
+	}
+
+}
+
+func TestValidateStacking(t *testing.T) {
+
+	invalid := []Stacking{
+		{Mode: "lowest", Keep: 1},
+		{Mode: ModeKeepLast, Keep: 0},
+		{Mode: ModeKeepLast, Keep: maxKeep + 1},
+	}
+	for _, s := range invalid {
+		if err := s.validate(); err == nil {
+			t.Errorf("Expected error for %+v\n", s)
+		}
+	}
+
+}
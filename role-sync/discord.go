@@ -0,0 +1,102 @@
+package remrolesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/yayuyokitano/rem-next/ratelimit"
+)
+
+const (
+	defaultSyncRateLimit = 10
+	// The most members Discord lists per request.
+	memberPageSize = 1000
+	auditLogReason = "Role reward sync"
+)
+
+// discordClient is shared by the whole sync, so the rate limit holds for every request it makes.
+var discordClient = ratelimit.NewClient(ratelimit.EnvInt("ROLE_SYNC_RATE_LIMIT", defaultSyncRateLimit), 30*time.Second)
+
+type discordMember struct {
+	User struct {
+		ID  string `json:"id"`
+		Bot bool   `json:"bot"`
+	} `json:"user"`
+	Roles []string `json:"roles"`
+}
+
+func discordRequest(ctx context.Context, method string, path string) (req *http.Request, err error) {
+	req, err = http.NewRequestWithContext(ctx, method, os.Getenv("DISCORD_BASE_URI")+path, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bot %s", os.Getenv("DISCORD_TOKEN")))
+	req.Header.Set("X-Audit-Log-Reason", auditLogReason)
+	return
+}
+
+// listMembers returns the next page of members with IDs above after, sorted by ID.
+func listMembers(ctx context.Context, guildID string, after string) (members []discordMember, err error) {
+
+	req, err := discordRequest(ctx, http.MethodGet, fmt.Sprintf("/guilds/%s/members?limit=%d&after=%s", guildID, memberPageSize, after))
+	if err != nil {
+		return
+	}
+	resp, err := discordClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Failed to list members: Discord responded with " + resp.Status)
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&members)
+	return
+
+}
+
+// setMemberRole adds the role to the member, or removes it when add is false.
+func setMemberRole(ctx context.Context, guildID string, userID string, roleID string, add bool) (err error) {
+
+	method := http.MethodDelete
+	if add {
+		method = http.MethodPut
+	}
+	req, err := discordRequest(ctx, method, fmt.Sprintf("/guilds/%s/members/%s/roles/%s", guildID, userID, roleID))
+	if err != nil {
+		return
+	}
+	resp, err := discordClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		err = &discordError{Status: resp.StatusCode, Message: "Discord responded with " + resp.Status}
+	}
+	return
+
+}
+
+type discordError struct {
+	Status  int
+	Message string
+}
+
+func (e *discordError) Error() string {
+	return e.Message
+}
+
+// isNotFound is true when Discord no longer knows the member or role, so there is nothing left to take away.
+func isNotFound(err error) bool {
+	var discordErr *discordError
+	return errors.As(err, &discordErr) && discordErr.Status == http.StatusNotFound
+}
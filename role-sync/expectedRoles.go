@@ -0,0 +1,138 @@
+package remrolesync
+
+import (
+	"sort"
+)
+
+// Stacking modes, matching the check on guilds.roleStacking.
+const (
+	modeCumulative = "cumulative"
+	modeHighest    = "highest"
+	modeKeepLast   = "keepLast"
+)
+
+type roleReward struct {
+	RoleID     string
+	Level      int
+	Persistent bool
+	// ExpiresAfter is how many seconds members keep the role, 0 for permanent rewards.
+	ExpiresAfter int
+	// TopN rewards are handed out and taken back by the expiry job alone, sync leaves them be.
+	TopN int
+}
+
+type stacking struct {
+	Mode string
+	Keep int
+}
+
+// MemberChange is what a sync does, or would do for dry runs, to one member's roles.
+type MemberChange struct {
+	UserID string   `json:"userID"`
+	Level  int      `json:"level"`
+	Add    []string `json:"add"`
+	Remove []string `json:"remove"`
+}
+
+// rewardPlan holds a guild's rewards sorted from the highest level down.
+type rewardPlan struct {
+	rewards  []roleReward
+	stacking stacking
+	// managed is every role handed out as a reward, sync never touches any other role.
+	managed map[string]bool
+	// persistent roles are never taken away once a member has them.
+	persistent map[string]bool
+	// expiring maps roles that lapse to how many seconds they are kept.
+	expiring map[string]int
+}
+
+func newRewardPlan(rewards []roleReward, s stacking) (plan rewardPlan) {
+
+	for _, reward := range rewards {
+		if reward.TopN == 0 {
+			plan.rewards = append(plan.rewards, reward)
+		}
+	}
+	sort.SliceStable(plan.rewards, func(i, j int) bool {
+		return plan.rewards[i].Level > plan.rewards[j].Level
+	})
+	plan.stacking = s
+	plan.managed = make(map[string]bool, len(rewards))
+	plan.persistent = make(map[string]bool)
+	plan.expiring = make(map[string]int)
+	for _, reward := range plan.rewards {
+		plan.managed[reward.RoleID] = true
+		if reward.Persistent {
+			plan.persistent[reward.RoleID] = true
+		}
+		if reward.ExpiresAfter > 0 {
+			plan.expiring[reward.RoleID] = reward.ExpiresAfter
+		}
+	}
+	return
+
+}
+
+// expected returns the reward roles a member at level should have.
+// Every role rewarded at the same level counts as one step for highest and keepLast.
+func (plan rewardPlan) expected(level int) map[string]bool {
+
+	keep := 0
+	switch plan.stacking.Mode {
+	case modeHighest:
+		keep = 1
+		break
+	case modeKeepLast:
+		keep = plan.stacking.Keep
+		break
+	}
+
+	expected := make(map[string]bool)
+	steps := 0
+	lastLevel := -1
+	for _, reward := range plan.rewards {
+		if reward.Level > level {
+			continue
+		}
+		if reward.Level != lastLevel {
+			steps++
+			lastLevel = reward.Level
+		}
+		// Persistent rewards are kept once reached, whatever the mode.
+		if keep == 0 || steps <= keep || reward.Persistent {
+			expected[reward.RoleID] = true
+		}
+	}
+	return expected
+
+}
+
+// diff works out which reward roles to add to and remove from a member who currently has roles.
+// Roles in lapsed have expired for the member and are not handed out again.
+func (plan rewardPlan) diff(userID string, level int, roles []string, lapsed map[string]bool) (change MemberChange, changed bool) {
+
+	expected := plan.expected(level)
+	for roleID := range lapsed {
+		delete(expected, roleID)
+	}
+	change = MemberChange{UserID: userID, Level: level, Add: make([]string, 0), Remove: make([]string, 0)}
+
+	has := make(map[string]bool, len(roles))
+	for _, roleID := range roles {
+		has[roleID] = true
+		if plan.managed[roleID] && !expected[roleID] && !plan.persistent[roleID] {
+			change.Remove = append(change.Remove, roleID)
+		}
+	}
+	for roleID := range expected {
+		if !has[roleID] {
+			change.Add = append(change.Add, roleID)
+		}
+	}
+	sort.Strings(change.Add)
+	sort.Strings(change.Remove)
+
+	changed = len(change.Add) > 0 || len(change.Remove) > 0
+	return
+
+}
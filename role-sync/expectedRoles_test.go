@@ -0,0 +1,86 @@
+package remrolesync
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpectedRoles(t *testing.T) {
+
+	rewards := []roleReward{
+		{RoleID: "956209277926768700", Level: 5},
+		{RoleID: "956209277926768701", Level: 10, Persistent: true},
+		{RoleID: "956209277926768702", Level: 20},
+		{RoleID: "956209277926768703", Level: 20},
+		{RoleID: "956209277926768704", Level: 30},
+	}
+
+	expected := map[string]map[int][]string{
+		modeCumulative: {
+			0:  {},
+			25: {"956209277926768700", "956209277926768701", "956209277926768702", "956209277926768703"},
+		},
+		modeHighest: {
+			7:  {"956209277926768700"},
+			25: {"956209277926768701", "956209277926768702", "956209277926768703"},
+			30: {"956209277926768701", "956209277926768704"},
+		},
+		modeKeepLast: {
+			25: {"956209277926768700", "956209277926768701", "956209277926768702", "956209277926768703"},
+			30: {"956209277926768701", "956209277926768702", "956209277926768703", "956209277926768704"},
+		},
+	}
+
+	for mode, levels := range expected {
+		plan := newRewardPlan(rewards, stacking{Mode: mode, Keep: 3})
+		for level, roles := range levels {
+			got := plan.expected(level)
+			want := make(map[string]bool, len(roles))
+			for _, roleID := range roles {
+				want[roleID] = true
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s at level %d: expected %v, got %v\n", mode, level, want, got)
+			}
+		}
+	}
+
+}
+
+func TestMemberDiff(t *testing.T) {
+
+	plan := newRewardPlan([]roleReward{
+		{RoleID: "956209277926768700", Level: 5},
+		{RoleID: "956209277926768701", Level: 10, Persistent: true},
+		{RoleID: "956209277926768702", Level: 20},
+	}, stacking{Mode: modeHighest, Keep: 1})
+
+	// Level 5 should only have the first reward, the persistent one is kept and unrelated roles are left alone.
+	roles := []string{"956209277926768701", "956209277926768702", "956209277926768799"}
+	change, changed := plan.diff("196249128286552064", 5, roles, nil)
+	if !changed {
+		t.Errorf("Expected changes\n")
+	}
+	if !reflect.DeepEqual(change.Add, []string{"956209277926768700"}) {
+		t.Errorf("Expected to add the level 5 role, got %v\n", change.Add)
+	}
+	if !reflect.DeepEqual(change.Remove, []string{"956209277926768702"}) {
+		t.Errorf("Expected to remove the level 20 role, got %v\n", change.Remove)
+	}
+
+	if _, changed = plan.diff("196249128286552064", 5, []string{"956209277926768700"}, nil); changed {
+		t.Errorf("Expected no changes for a member that already matches\n")
+	}
+
+	// Lapsed roles are taken away and not handed out again, top rewards are left to the expiry job.
+	plan = newRewardPlan([]roleReward{
+		{RoleID: "956209277926768700", Level: 5, ExpiresAfter: 3600},
+		{RoleID: "956209277926768701", Level: 0, TopN: 10},
+	}, stacking{Mode: modeCumulative})
+	lapsed := map[string]bool{"956209277926768700": true}
+	change, _ = plan.diff("196249128286552064", 5, []string{"956209277926768700", "956209277926768701"}, lapsed)
+	if len(change.Add) != 0 || !reflect.DeepEqual(change.Remove, []string{"956209277926768700"}) {
+		t.Errorf("Expected only the lapsed role to be removed, got %+v\n", change)
+	}
+
+}
@@ -0,0 +1,397 @@
+package remrolesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/jackc/pgx/v4"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+// The expiry job is run on a schedule, Cloud Scheduler publishes to roleexpiry to start it.
+const (
+	// Anything left over is picked up by the next run.
+	expiryBudget = 7 * time.Minute
+	// Expired grants taken back per guild and run, so one huge guild can't starve the rest.
+	maxExpiriesPerGuild = 1000
+)
+
+// Reasons a grant lapsed, sent to remraku.
+const (
+	lapseExpired = "expired"
+	lapseTopN    = "topN"
+)
+
+type Lapse struct {
+	UserID string `json:"userID"`
+	RoleID string `json:"roleID"`
+	Reason string `json:"reason"`
+}
+
+type topReward struct {
+	RoleID        string
+	Level         int
+	TopN          int
+	TopWindowDays int
+	ExpiresAfter  int
+}
+
+// RoleExpiryWorker takes back expired and top-N rewards, it is deployed as its own function triggered by the roleexpiry topic.
+func RoleExpiryWorker(ctx context.Context, m PubSubMessage) error {
+
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	guildIDs, err := guildsWithExpiringRewards(ctx)
+	if err != nil {
+		fmt.Println("Failed to list guilds with expiring rewards:", err)
+		return nil
+	}
+
+	deadline := time.Now().Add(expiryBudget)
+	for _, guildID := range guildIDs {
+		if time.Now().After(deadline) {
+			break
+		}
+		// One guild failing shouldn't keep the others from expiring their roles.
+		lapses, err := expireGuild(ctx, guildID)
+		if err != nil {
+			fmt.Println("Failed to expire roles of", guildID+":", err)
+		}
+		if len(lapses) == 0 {
+			continue
+		}
+		if err := pushLapsesToRemraku(ctx, guildID, lapses); err != nil {
+			fmt.Println("Failed to push lapsed roles of", guildID, "to Remraku:", err)
+		}
+	}
+	return nil
+
+}
+
+func guildsWithExpiringRewards(ctx context.Context) (guildIDs []string, err error) {
+
+	rows, err := pool.Query(ctx, `SELECT guildID FROM rolerewards WHERE expiresAfter IS NOT NULL OR topN IS NOT NULL
+		UNION SELECT guildID FROM rolerewardgrants WHERE revokedAt IS NULL AND expiresAt <= NOW()`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guildID string
+		err = rows.Scan(&guildID)
+		if err != nil {
+			return
+		}
+		guildIDs = append(guildIDs, guildID)
+	}
+	err = rows.Err()
+	return
+
+}
+
+// expireGuild takes back the guild's expired grants and brings its top-N rewards in line with the leaderboard.
+// The lapses are returned even on error, they have happened either way.
+func expireGuild(ctx context.Context, guildID string) (lapses []Lapse, err error) {
+
+	lapses, err = revokeExpiredGrants(ctx, guildID)
+	if err != nil {
+		return
+	}
+
+	rewards, err := fetchTopRewards(ctx, guildID)
+	if err != nil || len(rewards) == 0 {
+		return
+	}
+	var rawCurve []byte
+	err = pool.QueryRow(ctx, "SELECT levelCurve FROM guildsettings WHERE guildID = $1", guildID).Scan(&rawCurve)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	curve, err := levelcurve.Parse(rawCurve)
+	if err != nil {
+		return
+	}
+
+	for _, reward := range rewards {
+		var topLapses []Lapse
+		topLapses, err = syncTopReward(ctx, guildID, reward, curve)
+		lapses = append(lapses, topLapses...)
+		if err != nil {
+			return
+		}
+	}
+	return
+
+}
+
+func revokeExpiredGrants(ctx context.Context, guildID string) (lapses []Lapse, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT userID, roleID FROM rolerewardgrants WHERE guildID = $1 AND revokedAt IS NULL AND expiresAt <= NOW() ORDER BY expiresAt LIMIT $2", guildID, maxExpiriesPerGuild)
+	if err != nil {
+		return
+	}
+	var expired []Lapse
+	for rows.Next() {
+		lapse := Lapse{Reason: lapseExpired}
+		err = rows.Scan(&lapse.UserID, &lapse.RoleID)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		expired = append(expired, lapse)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	for _, lapse := range expired {
+		if !revokeGrant(ctx, guildID, lapse) {
+			continue
+		}
+		lapses = append(lapses, lapse)
+	}
+	return
+
+}
+
+// revokeGrant takes the role away and marks the grant revoked, it is tried again on the next run when Discord refuses.
+func revokeGrant(ctx context.Context, guildID string, lapse Lapse) (revoked bool) {
+
+	err := setMemberRole(ctx, guildID, lapse.UserID, lapse.RoleID, false)
+	if err != nil && !isNotFound(err) {
+		fmt.Println("Failed to take role", lapse.RoleID, "from", lapse.UserID+":", err)
+		return false
+	}
+
+	_, err = pool.Exec(ctx, "UPDATE rolerewardgrants SET revokedAt = NOW() WHERE guildID = $1 AND userID = $2 AND roleID = $3", guildID, lapse.UserID, lapse.RoleID)
+	if err != nil {
+		fmt.Println("Failed to mark role grant revoked:", err)
+		return false
+	}
+	return true
+
+}
+
+func fetchTopRewards(ctx context.Context, guildID string) (rewards []topReward, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT roleID, level, topN, COALESCE(topWindowDays, 0), COALESCE(expiresAfter, 0) FROM rolerewards WHERE guildID = $1 AND topN IS NOT NULL", guildID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reward topReward
+		err = rows.Scan(&reward.RoleID, &reward.Level, &reward.TopN, &reward.TopWindowDays, &reward.ExpiresAfter)
+		if err != nil {
+			return
+		}
+		rewards = append(rewards, reward)
+	}
+	err = rows.Err()
+	return
+
+}
+
+// syncTopReward hands the role to the members ranked in the top N that reached its level, and takes it from everyone else holding it.
+func syncTopReward(ctx context.Context, guildID string, reward topReward, curve levelcurve.Curve) (lapses []Lapse, err error) {
+
+	ranked, err := fetchTopMembers(ctx, guildID, reward.TopN, reward.TopWindowDays)
+	if err != nil {
+		return
+	}
+	eligible := eligibleMembers(ranked, curve, reward.Level)
+
+	rows, err := pool.Query(ctx, "SELECT userID, revokedAt IS NOT NULL FROM rolerewardgrants WHERE guildID = $1 AND roleID = $2", guildID, reward.RoleID)
+	if err != nil {
+		return
+	}
+	holders := make(map[string]bool)
+	lapsed := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		var revoked bool
+		err = rows.Scan(&userID, &revoked)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		if revoked {
+			lapsed[userID] = true
+		} else {
+			holders[userID] = true
+		}
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	plan := planTopReward(eligible, holders, lapsed)
+	for _, userID := range plan.revoke {
+		lapse := Lapse{UserID: userID, RoleID: reward.RoleID, Reason: lapseTopN}
+		if revokeGrant(ctx, guildID, lapse) {
+			lapses = append(lapses, lapse)
+			plan.forget = append(plan.forget, userID)
+		}
+	}
+
+	if len(plan.forget) > 0 {
+		_, err = pool.Exec(ctx, "DELETE FROM rolerewardgrants WHERE guildID = $1 AND roleID = $2 AND userID = ANY($3) AND revokedAt IS NOT NULL", guildID, reward.RoleID, plan.forget)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, userID := range plan.grant {
+		if err := setMemberRole(ctx, guildID, userID, reward.RoleID, true); err != nil {
+			// Members who left stay on the leaderboard, they just can't be given anything.
+			if !isNotFound(err) {
+				fmt.Println("Failed to give role", reward.RoleID, "to", userID+":", err)
+			}
+			continue
+		}
+		grantTopReward(ctx, guildID, userID, reward)
+	}
+	return
+
+}
+
+type topRewardPlan struct {
+	revoke []string
+	grant  []string
+	// forget lists members whose lapsed grant no longer matters, so they can earn the reward again.
+	forget []string
+}
+
+// planTopReward works out who loses and who gets a top-N reward.
+// A member whose reward expired while still ranked stays without it until they drop out of the top N,
+// otherwise it would be handed straight back on the same run it was taken.
+func planTopReward(eligible map[string]bool, holders map[string]bool, lapsed map[string]bool) (plan topRewardPlan) {
+	for userID := range holders {
+		if !eligible[userID] {
+			plan.revoke = append(plan.revoke, userID)
+		}
+	}
+	for userID := range lapsed {
+		if !eligible[userID] {
+			plan.forget = append(plan.forget, userID)
+		}
+	}
+	for userID := range eligible {
+		if !holders[userID] && !lapsed[userID] {
+			plan.grant = append(plan.grant, userID)
+		}
+	}
+	sort.Strings(plan.revoke)
+	sort.Strings(plan.forget)
+	sort.Strings(plan.grant)
+	return
+}
+
+type rankedMember struct {
+	userID string
+	xp     int64
+}
+
+// eligibleMembers picks the ranked members who also reached level, ranking high isn't enough on its own.
+func eligibleMembers(ranked []rankedMember, curve levelcurve.Curve, level int) map[string]bool {
+	eligible := make(map[string]bool, len(ranked))
+	for _, member := range ranked {
+		if levelcurve.LevelForXP(curve, member.xp) >= level {
+			eligible[member.userID] = true
+		}
+	}
+	return eligible
+}
+
+// fetchTopMembers ranks by total xp, or by xp gained over the last windowDays when it is set.
+// Imports, resets and restores move xp around without anyone earning it, so they don't count towards the window.
+func fetchTopMembers(ctx context.Context, guildID string, n int, windowDays int) (ranked []rankedMember, err error) {
+
+	query := "SELECT userID, xp FROM guildxp WHERE guildID = $1 AND xp > 0 ORDER BY xp DESC, userID LIMIT $2"
+	args := []interface{}{guildID, n}
+	if windowDays > 0 {
+		query = `SELECT l.userID, g.xp FROM (
+				SELECT userID, SUM(delta) AS gained FROM xpledger
+				WHERE guildID = $1 AND createdAt > NOW() - make_interval(days => $3) AND source NOT LIKE 'import:%' AND source NOT IN ('reset', 'restore')
+				GROUP BY userID HAVING SUM(delta) > 0
+			) l JOIN guildxp g ON g.guildID = $1 AND g.userID = l.userID
+			ORDER BY l.gained DESC, l.userID LIMIT $2`
+		args = append(args, windowDays)
+	}
+
+	rows, err := pool.Query(ctx, query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var member rankedMember
+		err = rows.Scan(&member.userID, &member.xp)
+		if err != nil {
+			return
+		}
+		ranked = append(ranked, member)
+	}
+	err = rows.Err()
+	return
+
+}
+
+func grantTopReward(ctx context.Context, guildID string, userID string, reward topReward) {
+	var expiresAfter interface{}
+	if reward.ExpiresAfter > 0 {
+		expiresAfter = reward.ExpiresAfter
+	}
+	_, err := pool.Exec(ctx, `INSERT INTO rolerewardgrants (guildID, userID, roleID, expiresAt) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+		ON CONFLICT (guildID, userID, roleID) DO UPDATE SET grantedAt = NOW(), expiresAt = EXCLUDED.expiresAt, revokedAt = NULL`,
+		guildID, userID, reward.RoleID, expiresAfter)
+	if err != nil {
+		fmt.Println("Failed to record role grant:", err)
+	}
+}
+
+type lapsedMessage struct {
+	Type    string  `json:"type"`
+	GuildID string  `json:"guildID"`
+	Lapsed  []Lapse `json:"lapsed"`
+}
+
+func pushLapsesToRemraku(ctx context.Context, guildID string, lapses []Lapse) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(lapsedMessage{
+		Type:    "rolerewardslapsed",
+		GuildID: guildID,
+		Lapsed:  lapses,
+	})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(ctx, m).Get(ctx)
+	return
+
+}
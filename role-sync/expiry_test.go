@@ -0,0 +1,135 @@
+package remrolesync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+func TestEligibleMembers(t *testing.T) {
+
+	curve := levelcurve.Linear{XPPerLevel: 100}
+	ranked := []rankedMember{
+		{userID: "196249128286552064", xp: 1500},
+		{userID: "196249128286552065", xp: 1000},
+		{userID: "196249128286552066", xp: 999},
+	}
+
+	// The third member ranks high enough but hasn't reached level 10 yet.
+	got := eligibleMembers(ranked, curve, 10)
+	want := map[string]bool{"196249128286552064": true, "196249128286552065": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v\n", want, got)
+	}
+
+	if got := eligibleMembers(nil, curve, 0); len(got) != 0 {
+		t.Errorf("Expected nobody to be eligible on an empty leaderboard, got %v\n", got)
+	}
+
+}
+
+func TestPlanTopReward(t *testing.T) {
+
+	eligible := map[string]bool{"196249128286552064": true, "196249128286552065": true, "196249128286552066": true}
+	holders := map[string]bool{"196249128286552064": true, "196249128286552067": true}
+	// 065 still ranks but their reward just expired, 068 expired and has dropped out since.
+	lapsed := map[string]bool{"196249128286552065": true, "196249128286552068": true}
+
+	got := planTopReward(eligible, holders, lapsed)
+	want := topRewardPlan{
+		revoke: []string{"196249128286552067"},
+		grant:  []string{"196249128286552066"},
+		forget: []string{"196249128286552068"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v\n", want, got)
+	}
+
+}
+
+const testExpiryGuildID = "196249128286552100"
+
+func TestRevokeExpiredGrants(t *testing.T) {
+
+	if os.Getenv("DATABASE_PRIVATE_URL") == "" {
+		t.Skip("DATABASE_PRIVATE_URL not set")
+	}
+	if err := createPool(); err != nil {
+		t.Errorf("Failed to create pool: %s\n", err)
+		return
+	}
+
+	removed := make(map[string]bool)
+	discord := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodDelete {
+			removed[request.URL.Path] = true
+		}
+		writer.WriteHeader(http.StatusNoContent)
+	}))
+	defer discord.Close()
+	os.Setenv("DISCORD_BASE_URI", discord.URL)
+	defer os.Unsetenv("DISCORD_BASE_URI")
+
+	ctx := context.Background()
+	cleanup := func() {
+		pool.Exec(ctx, "DELETE FROM rolerewardgrants WHERE guildID = $1", testExpiryGuildID)
+	}
+	cleanup()
+	defer cleanup()
+
+	grants := []struct {
+		userID  string
+		expires string
+		revoked bool
+	}{
+		{"196249128286552101", "-1 hour", false},
+		{"196249128286552102", "1 hour", false},
+		{"196249128286552103", "-1 hour", true},
+	}
+	for _, grant := range grants {
+		_, err := pool.Exec(ctx, `INSERT INTO rolerewardgrants (guildID, userID, roleID, expiresAt, revokedAt)
+			VALUES ($1, $2, '196249128286552110', NOW() + $3::interval, CASE WHEN $4 THEN NOW() END)`, testExpiryGuildID, grant.userID, grant.expires, grant.revoked)
+		if err != nil {
+			t.Errorf("Failed to insert grant: %s\n", err)
+			return
+		}
+	}
+
+	guildIDs, err := guildsWithExpiringRewards(ctx)
+	if err != nil {
+		t.Errorf("Failed to list guilds: %s\n", err)
+		return
+	}
+	found := false
+	for _, guildID := range guildIDs {
+		found = found || guildID == testExpiryGuildID
+	}
+	if !found {
+		t.Errorf("Expected the guild with an expired grant to be listed\n")
+	}
+
+	lapses, err := revokeExpiredGrants(ctx, testExpiryGuildID)
+	if err != nil {
+		t.Errorf("Failed to revoke expired grants: %s\n", err)
+		return
+	}
+	want := []Lapse{{UserID: "196249128286552101", RoleID: "196249128286552110", Reason: lapseExpired}}
+	if !reflect.DeepEqual(lapses, want) {
+		t.Errorf("Expected only the expired grant to lapse, got %v\n", lapses)
+	}
+	if len(removed) != 1 {
+		t.Errorf("Expected one role to be taken away, got %v\n", removed)
+	}
+
+	var active int
+	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM rolerewardgrants WHERE guildID = $1 AND revokedAt IS NULL", testExpiryGuildID).Scan(&active)
+	if err != nil || active != 1 {
+		t.Errorf("Expected only the grant that hasn't expired to stay active, got %d: %v\n", active, err)
+	}
+
+}
@@ -0,0 +1,71 @@
+package remrolesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// grantMessage is sent by remraku for the reward roles it hands out on level up.
+// Syncs and top-N rewards record their grants themselves, level ups are the only path that happens in the bot.
+type grantMessage struct {
+	GuildID string   `json:"guildID"`
+	UserID  string   `json:"userID"`
+	RoleIDs []string `json:"roleIDs"`
+}
+
+// RoleGrantWorker starts the countdown on the expiring rewards remraku hands out,
+// it is deployed as its own function triggered by the rolerewardgrants topic.
+func RoleGrantWorker(ctx context.Context, m PubSubMessage) error {
+
+	var message grantMessage
+	if err := json.Unmarshal(m.Data, &message); err != nil {
+		// Retrying a message that can't be read would never help.
+		fmt.Println("Invalid role grant message:", err)
+		return nil
+	}
+
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	if err := recordLevelUpGrants(ctx, message); err != nil {
+		fmt.Println("Failed to record role grants of", message.UserID, "in", message.GuildID+":", err)
+	}
+	return nil
+
+}
+
+// recordLevelUpGrants records a grant for each of the roles that is an expiring reward, the others never lapse.
+// Top-N rewards are left out, the expiry job hands those out and records them itself.
+func recordLevelUpGrants(ctx context.Context, message grantMessage) (err error) {
+
+	rows, err := pool.Query(ctx, `SELECT roleID, MAX(expiresAfter) FROM rolerewards
+		WHERE guildID = $1 AND roleID = ANY($2) AND expiresAfter IS NOT NULL AND topN IS NULL GROUP BY roleID`, message.GuildID, message.RoleIDs)
+	if err != nil {
+		return
+	}
+	expiring := make(map[string]int)
+	for rows.Next() {
+		var roleID string
+		var seconds int
+		err = rows.Scan(&roleID, &seconds)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		expiring[roleID] = seconds
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	// The role was just handed out, so it counts down from now even if the member held it before.
+	for roleID, seconds := range expiring {
+		recordGrant(ctx, message.GuildID, message.UserID, roleID, seconds, true)
+	}
+	return
+
+}
@@ -0,0 +1,274 @@
+package remrolesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+func init() {
+	functions.HTTP("role-sync", roleSync)
+}
+
+var client *pubsub.Client
+
+var pool *pgxpool.Pool
+
+func createPool() (err error) {
+	if pool == nil {
+		ctx := context.Background()
+
+		pool, err = pgxpool.Connect(ctx, os.Getenv("DATABASE_PRIVATE_URL"))
+	}
+	return
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func corsHandler(writer http.ResponseWriter, request *http.Request) {
+	allowed := strings.Split(os.Getenv("ALLOWED_ORIGINS"), "||")
+	origin := request.Header.Get("Origin")
+	if contains(allowed, origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+type Params struct {
+	GuildID string `json:"guildID"`
+	UserID  string `json:"userID"`
+	Token   int64  `json:"token"`
+	// DryRun only reports what would change for each member.
+	DryRun bool `json:"dryRun"`
+}
+
+type SyncJob struct {
+	ID             int64          `json:"id"`
+	DryRun         bool           `json:"dryRun"`
+	Status         string         `json:"status"`
+	MembersChecked int            `json:"membersChecked"`
+	MembersChanged int            `json:"membersChanged"`
+	RolesAdded     int            `json:"rolesAdded"`
+	RolesRemoved   int            `json:"rolesRemoved"`
+	Changes        []MemberChange `json:"changes"`
+	Errors         []string       `json:"errors"`
+	CreatedAt      time.Time      `json:"createdAt"`
+	UpdatedAt      time.Time      `json:"updatedAt"`
+}
+
+type syncJobMessage struct {
+	JobID int64 `json:"jobID"`
+}
+
+func roleSync(writer http.ResponseWriter, request *http.Request) {
+
+	corsHandler(writer, request)
+
+	switch request.Method {
+	case "GET":
+		getSyncJob(writer, request)
+		break
+	case "POST":
+		postSyncJob(writer, request)
+		break
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(writer, "Method not allowed")
+		break
+	}
+
+}
+
+// getSyncJob reports on a job of the guild, or its latest job when jobID is left out.
+func getSyncJob(writer http.ResponseWriter, request *http.Request) {
+
+	urlParams := request.URL.Query()
+	guildID := urlParams.Get("guildID")
+	userID := urlParams.Get("userID")
+	token, err := strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil || guildID == "" || userID == "" || token == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+	var jobID int64
+	if raw := urlParams.Get("jobID"); raw != "" {
+		jobID, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(writer, "Invalid parameters: ", err)
+			return
+		}
+	}
+
+	if err := confirmPermission(guildID, userID, token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	if err := createPool(); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to create pool: ", err)
+		return
+	}
+
+	job, err := fetchSyncJob(request.Context(), guildID, jobID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		writer.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(writer, "Role sync job not found")
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role sync job: ", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(job)
+
+}
+
+func postSyncJob(writer http.ResponseWriter, request *http.Request) {
+
+	var params Params
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	if params.GuildID == "" || params.Token == 0 || params.UserID == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	if err := createPool(); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to create pool: ", err)
+		return
+	}
+
+	jobID, err := enqueueSync(request.Context(), params)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to start role sync: ", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(writer).Encode(syncJobMessage{JobID: jobID})
+
+}
+
+func confirmPermission(guildID string, callerID string, token int64) (err error) {
+
+	client := &http.Client{}
+	resp, err := client.Post(os.Getenv("GCP_BASE_URI")+"/confirm-permission", "application/json", strings.NewReader(fmt.Sprintf(`{"guildID":"%s","userID":"%s","token":%d}`, guildID, callerID, token)))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Invalid token or user, or insufficient guild permissions: " + string(rawBody))
+		return
+	}
+	return
+}
+
+func enqueueSync(ctx context.Context, params Params) (jobID int64, err error) {
+
+	err = pool.QueryRow(ctx, "INSERT INTO rolesyncjobs (guildID, callerID, dryRun) VALUES ($1, $2, $3) RETURNING id", params.GuildID, params.UserID, params.DryRun).Scan(&jobID)
+	if err != nil {
+		return
+	}
+
+	err = publishSyncJob(ctx, jobID)
+	if err != nil {
+		// Nothing will ever pick the job up, so don't leave it looking queued.
+		if failErr := failSyncJob(ctx, jobID, err); failErr != nil {
+			fmt.Println("Failed to mark role sync job", jobID, "as failed:", failErr)
+		}
+	}
+	return
+
+}
+
+func publishSyncJob(ctx context.Context, jobID int64) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(syncJobMessage{JobID: jobID})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic(syncJobsTopic).Publish(ctx, m).Get(ctx)
+	return
+
+}
+
+func fetchSyncJob(ctx context.Context, guildID string, jobID int64) (job SyncJob, err error) {
+
+	query := `SELECT id, dryRun, status, membersChecked, membersChanged, rolesAdded, rolesRemoved, changes, errors, createdAt, updatedAt
+		FROM rolesyncjobs WHERE guildID = $1 AND (id = $2 OR $2 = 0) ORDER BY id DESC LIMIT 1`
+
+	var changes []byte
+	err = pool.QueryRow(ctx, query, guildID, jobID).Scan(
+		&job.ID,
+		&job.DryRun,
+		&job.Status,
+		&job.MembersChecked,
+		&job.MembersChanged,
+		&job.RolesAdded,
+		&job.RolesRemoved,
+		&changes,
+		&job.Errors,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(changes, &job.Changes)
+	return
+
+}
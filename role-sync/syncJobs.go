@@ -0,0 +1,414 @@
+package remrolesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+// Syncs go through every member of the guild, so like imports they run as jobs that can span several invocations.
+const (
+	syncJobsTopic = "rolesyncjobs"
+	// Workers hand the job on to a fresh invocation well before the function timeout catches up with them.
+	syncWorkerBudget = 7 * time.Minute
+	// The lease outlives the budget, so no other worker claims a job that is still being worked on.
+	// Once it runs out the job is only picked up again when RoleSyncSweeper queues it.
+	syncJobLease = 10 * time.Minute
+	// Dry runs list this many members at most, the counts still cover everyone.
+	maxReportedChanges = 1000
+	maxJobErrors       = 100
+)
+
+// syncJobState is what a worker needs to carry on with a job.
+type syncJobState struct {
+	ID          int64
+	GuildID     string
+	DryRun      bool
+	AfterMember string
+	Reported    int
+}
+
+// syncProgress is what a worker has done since it last saved.
+type syncProgress struct {
+	afterMember string
+	checked     int
+	changed     int
+	added       int
+	removed     int
+	changes     []MemberChange
+}
+
+// PubSubMessage is the payload of the event that triggers RoleSyncWorker.
+type PubSubMessage struct {
+	Data []byte `json:"data"`
+}
+
+// RoleSyncWorker processes role sync jobs, it is deployed as its own function triggered by the rolesyncjobs topic.
+func RoleSyncWorker(ctx context.Context, m PubSubMessage) error {
+
+	var message syncJobMessage
+	if err := json.Unmarshal(m.Data, &message); err != nil {
+		// Retrying a message that can't be read would never help.
+		fmt.Println("Invalid role sync job message:", err)
+		return nil
+	}
+
+	if err := createPool(); err != nil {
+		return err
+	}
+
+	if err := runSyncJob(ctx, message.JobID); err != nil {
+		fmt.Println("Role sync job", message.JobID, "failed:", err)
+	}
+	return nil
+
+}
+
+// RoleSyncSweeper queues jobs again whose worker died or whose message was lost.
+// It is deployed as its own function, Cloud Scheduler publishes to rolesyncsweep to start it.
+func RoleSyncSweeper(ctx context.Context, m PubSubMessage) (err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	// Queued jobs have no lease, they count as lost once they have waited as long as a lease lasts.
+	rows, err := pool.Query(ctx, `SELECT id FROM rolesyncjobs WHERE status IN ('queued', 'running')
+		AND COALESCE(leaseUntil, updatedAt + make_interval(secs => $1)) < NOW()`, syncJobLease.Seconds())
+	if err != nil {
+		return
+	}
+	var jobIDs []int64
+	for rows.Next() {
+		var jobID int64
+		err = rows.Scan(&jobID)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	// Workers claim a job through its lease, so a job that is queued twice still only runs once.
+	for _, jobID := range jobIDs {
+		if publishErr := publishSyncJob(ctx, jobID); publishErr != nil {
+			fmt.Println("Failed to queue role sync job", jobID, "again:", publishErr)
+			err = publishErr
+		}
+	}
+	return
+
+}
+
+func runSyncJob(ctx context.Context, jobID int64) (err error) {
+
+	job, claimed, err := claimSyncJob(ctx, jobID)
+	if err != nil || !claimed {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			if failErr := failSyncJob(context.Background(), job.ID, err); failErr != nil {
+				fmt.Println("Failed to mark role sync job", job.ID, "as failed:", failErr)
+			}
+		}
+	}()
+
+	plan, curve, err := loadRewardPlan(ctx, job.GuildID)
+	if err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(syncWorkerBudget)
+	for len(plan.managed) > 0 {
+		var members []discordMember
+		members, err = listMembers(ctx, job.GuildID, job.AfterMember)
+		if err != nil {
+			return
+		}
+		if len(members) == 0 {
+			break
+		}
+
+		userIDs := make([]string, len(members))
+		for i, member := range members {
+			userIDs[i] = member.User.ID
+		}
+		var xp map[string]int64
+		xp, err = fetchMemberXP(ctx, job.GuildID, userIDs)
+		if err != nil {
+			return
+		}
+		var lapsed map[string]map[string]bool
+		lapsed, err = fetchLapsedGrants(ctx, job.GuildID, userIDs)
+		if err != nil {
+			return
+		}
+
+		progress := syncProgress{afterMember: job.AfterMember}
+		for _, member := range members {
+			if time.Now().After(deadline) {
+				err = saveSyncProgress(ctx, job.ID, progress)
+				if err != nil {
+					return
+				}
+				err = yieldSyncJob(ctx, job.ID)
+				return
+			}
+			syncMember(ctx, job, plan, curve, member, xp[member.User.ID], lapsed[member.User.ID], &progress)
+		}
+
+		err = saveSyncProgress(ctx, job.ID, progress)
+		if err != nil {
+			return
+		}
+		job.AfterMember = progress.afterMember
+		job.Reported += len(progress.changes)
+		if len(members) < memberPageSize {
+			break
+		}
+	}
+
+	_, err = pool.Exec(ctx, "UPDATE rolesyncjobs SET status = 'done', leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", job.ID)
+	return
+
+}
+
+// syncMember brings one member's reward roles in line with their level, or only records the change for dry runs.
+// Failed role changes are recorded on the job and skipped, one role the bot can't manage shouldn't stop the sync.
+func syncMember(ctx context.Context, job syncJobState, plan rewardPlan, curve levelcurve.Curve, member discordMember, xp int64, lapsed map[string]bool, progress *syncProgress) {
+
+	progress.afterMember = member.User.ID
+	// Bots never earn xp, so they are left alone.
+	if member.User.Bot {
+		return
+	}
+	progress.checked++
+
+	change, changed := plan.diff(member.User.ID, levelcurve.LevelForXP(curve, xp), member.Roles, lapsed)
+	if !job.DryRun {
+		// Expiring roles handed out some other way start counting down from when sync first sees them.
+		for _, roleID := range member.Roles {
+			if seconds, ok := plan.expiring[roleID]; ok && !lapsed[roleID] {
+				recordGrant(ctx, job.GuildID, member.User.ID, roleID, seconds, false)
+			}
+		}
+	}
+	if !changed {
+		return
+	}
+	progress.changed++
+
+	if job.DryRun {
+		progress.added += len(change.Add)
+		progress.removed += len(change.Remove)
+		if job.Reported+len(progress.changes) < maxReportedChanges {
+			progress.changes = append(progress.changes, change)
+		}
+		return
+	}
+
+	for _, roleID := range change.Add {
+		if err := setMemberRole(ctx, job.GuildID, member.User.ID, roleID, true); err != nil {
+			addSyncJobError(ctx, job.ID, fmt.Sprintf("Adding role %s to %s: %s", roleID, member.User.ID, err))
+			continue
+		}
+		if seconds, ok := plan.expiring[roleID]; ok {
+			recordGrant(ctx, job.GuildID, member.User.ID, roleID, seconds, true)
+		}
+		progress.added++
+	}
+	for _, roleID := range change.Remove {
+		if err := setMemberRole(ctx, job.GuildID, member.User.ID, roleID, false); err != nil {
+			addSyncJobError(ctx, job.ID, fmt.Sprintf("Removing role %s from %s: %s", roleID, member.User.ID, err))
+			continue
+		}
+		progress.removed++
+	}
+
+}
+
+// claimSyncJob leases the job to this worker, claimed is false when it is finished or another worker holds it.
+func claimSyncJob(ctx context.Context, jobID int64) (job syncJobState, claimed bool, err error) {
+
+	err = pool.QueryRow(ctx, `UPDATE rolesyncjobs SET status = 'running', leaseUntil = NOW() + make_interval(secs => $2), updatedAt = NOW()
+		WHERE id = $1 AND status IN ('queued', 'running') AND (leaseUntil IS NULL OR leaseUntil < NOW())
+		RETURNING id, guildID, dryRun, afterMember, jsonb_array_length(changes)`, jobID, syncJobLease.Seconds()).Scan(
+		&job.ID,
+		&job.GuildID,
+		&job.DryRun,
+		&job.AfterMember,
+		&job.Reported,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = nil
+		return
+	}
+	claimed = err == nil
+	return
+
+}
+
+// yieldSyncJob releases the lease and queues the job again, the next worker resumes after the last saved member.
+func yieldSyncJob(ctx context.Context, jobID int64) (err error) {
+
+	_, err = pool.Exec(ctx, "UPDATE rolesyncjobs SET leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", jobID)
+	if err != nil {
+		return
+	}
+
+	err = publishSyncJob(ctx, jobID)
+	return
+
+}
+
+// loadRewardPlan reads the guild's rewards, stacking mode and level curve.
+func loadRewardPlan(ctx context.Context, guildID string) (plan rewardPlan, curve levelcurve.Curve, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT roleID, level, persistent, COALESCE(expiresAfter, 0), COALESCE(topN, 0) FROM rolerewards WHERE guildID = $1", guildID)
+	if err != nil {
+		return
+	}
+	var rewards []roleReward
+	for rows.Next() {
+		var reward roleReward
+		err = rows.Scan(&reward.RoleID, &reward.Level, &reward.Persistent, &reward.ExpiresAfter, &reward.TopN)
+		if err != nil {
+			rows.Close()
+			return
+		}
+		rewards = append(rewards, reward)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	// Matches the column defaults in guilds.
+	s := stacking{Mode: modeHighest, Keep: 1}
+	err = pool.QueryRow(ctx, "SELECT roleStacking, roleStackingKeep FROM guilds WHERE guildID = $1", guildID).Scan(&s.Mode, &s.Keep)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+
+	var rawCurve []byte
+	err = pool.QueryRow(ctx, "SELECT levelCurve FROM guildsettings WHERE guildID = $1", guildID).Scan(&rawCurve)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	curve, err = levelcurve.Parse(rawCurve)
+	if err != nil {
+		return
+	}
+
+	plan = newRewardPlan(rewards, s)
+	return
+
+}
+
+// fetchMemberXP returns the xp of the members that have any, the rest are at level 0.
+func fetchMemberXP(ctx context.Context, guildID string, userIDs []string) (xp map[string]int64, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT userID, xp FROM guildxp WHERE guildID = $1 AND userID = ANY($2)", guildID, userIDs)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	xp = make(map[string]int64, len(userIDs))
+	for rows.Next() {
+		var userID string
+		var userXP int64
+		err = rows.Scan(&userID, &userXP)
+		if err != nil {
+			return
+		}
+		xp[userID] = userXP
+	}
+	err = rows.Err()
+	return
+
+}
+
+// fetchLapsedGrants returns, per member, the expiring roles that have already been taken back from them.
+func fetchLapsedGrants(ctx context.Context, guildID string, userIDs []string) (lapsed map[string]map[string]bool, err error) {
+
+	rows, err := pool.Query(ctx, "SELECT userID, roleID FROM rolerewardgrants WHERE guildID = $1 AND userID = ANY($2) AND revokedAt IS NOT NULL", guildID, userIDs)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	lapsed = make(map[string]map[string]bool)
+	for rows.Next() {
+		var userID, roleID string
+		err = rows.Scan(&userID, &roleID)
+		if err != nil {
+			return
+		}
+		if lapsed[userID] == nil {
+			lapsed[userID] = make(map[string]bool)
+		}
+		lapsed[userID][roleID] = true
+	}
+	err = rows.Err()
+	return
+
+}
+
+// recordGrant starts the countdown on an expiring role, restart resets it for a role that was just handed out again.
+func recordGrant(ctx context.Context, guildID string, userID string, roleID string, seconds int, restart bool) {
+	query := `INSERT INTO rolerewardgrants (guildID, userID, roleID, expiresAt) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4)) ON CONFLICT DO NOTHING`
+	if restart {
+		query = `INSERT INTO rolerewardgrants (guildID, userID, roleID, expiresAt) VALUES ($1, $2, $3, NOW() + make_interval(secs => $4))
+			ON CONFLICT (guildID, userID, roleID) DO UPDATE SET grantedAt = NOW(), expiresAt = EXCLUDED.expiresAt, revokedAt = NULL`
+	}
+	_, err := pool.Exec(ctx, query, guildID, userID, roleID, seconds)
+	if err != nil {
+		fmt.Println("Failed to record role grant:", err)
+	}
+}
+
+// saveSyncProgress adds progress to the job's totals and moves the checkpoint past the members it covers.
+func saveSyncProgress(ctx context.Context, jobID int64, progress syncProgress) (err error) {
+
+	changes := progress.changes
+	if changes == nil {
+		changes = make([]MemberChange, 0)
+	}
+	rawChanges, err := json.Marshal(changes)
+	if err != nil {
+		return
+	}
+
+	_, err = pool.Exec(ctx, `UPDATE rolesyncjobs SET afterMember = $2, membersChecked = membersChecked + $3, membersChanged = membersChanged + $4,
+		rolesAdded = rolesAdded + $5, rolesRemoved = rolesRemoved + $6, changes = changes || $7::jsonb, updatedAt = NOW() WHERE id = $1`,
+		jobID, progress.afterMember, progress.checked, progress.changed, progress.added, progress.removed, rawChanges)
+	return
+
+}
+
+func addSyncJobError(ctx context.Context, jobID int64, message string) {
+	_, err := pool.Exec(ctx, "UPDATE rolesyncjobs SET errors = array_append(errors, $2), updatedAt = NOW() WHERE id = $1 AND cardinality(errors) < $3", jobID, message, maxJobErrors)
+	if err != nil {
+		fmt.Println("Failed to record role sync job error:", err)
+	}
+}
+
+func failSyncJob(ctx context.Context, jobID int64, cause error) (err error) {
+	_, err = pool.Exec(ctx, "UPDATE rolesyncjobs SET status = 'failed', errors = array_append(errors, $2), leaseUntil = NULL, updatedAt = NOW() WHERE id = $1", jobID, cause.Error())
+	return
+}
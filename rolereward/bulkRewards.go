@@ -0,0 +1,389 @@
+package remrolereward
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/jackc/pgx/v4"
+	"github.com/yayuyokitano/rem-next/confirmation"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+const maxRoleRewards = 250
+
+var errRewardsChanged = errors.New("Role rewards were changed while saving, try again")
+
+type BulkParams struct {
+	GuildID string `json:"guildID"`
+	Token   int64  `json:"token"`
+	UserID  string `json:"userID"`
+	// Rewards is the full set the guild should end up with, anything missing from it is removed.
+	Rewards []RoleReward `json:"rewards"`
+	// ConfirmationToken is needed when the new set removes rewards, see ConfirmationRequired.
+	ConfirmationToken string `json:"confirmationToken"`
+}
+
+type RoleRewardDiff struct {
+	Added   []RoleReward `json:"added"`
+	Updated []RoleReward `json:"updated"`
+	Removed []RoleReward `json:"removed"`
+}
+
+func (d RoleRewardDiff) empty() bool {
+	return len(d.Added) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0
+}
+
+// ConfirmationRequired is sent instead of applying a set that removes rewards,
+// the set is applied once confirmationToken is sent back with the same rewards.
+type ConfirmationRequired struct {
+	Diff              RoleRewardDiff `json:"diff"`
+	ConfirmationToken string         `json:"confirmationToken"`
+	ExpiresAt         time.Time      `json:"expiresAt"`
+}
+
+type rewardKey struct {
+	roleID string
+	level  int
+}
+
+func putRoleRewards(writer http.ResponseWriter, request *http.Request) {
+
+	var params BulkParams
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	if params.GuildID == "" || params.Token == 0 || params.UserID == "" || params.Rewards == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	if err := validateRewards(params.Rewards); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+
+	// Discord is asked before the rewards are locked, so a slow response never holds up other writes to the guild.
+	ctx := request.Context()
+	checked, err := fetchRoleRewards(ctx, params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role rewards: ", err)
+		return
+	}
+	err = checkChangedRewards(ctx, params.GuildID, checked, params.Rewards)
+	var validationErr *RewardValidationError
+	if errors.As(err, &validationErr) {
+		writer.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(writer).Encode(validationErr)
+		return
+	}
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch guild roles: ", err)
+		return
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to begin transaction: ", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, err := lockRoleRewards(ctx, tx, params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role rewards: ", err)
+		return
+	}
+	// Which rewards were checked depends on the rewards the guild had, so they have to be the ones locked.
+	if !sameRewards(checked, current) {
+		writer.WriteHeader(http.StatusConflict)
+		fmt.Fprint(writer, errRewardsChanged)
+		return
+	}
+	diff := diffRoleRewards(current, params.Rewards)
+
+	if len(diff.Removed) > 0 {
+		operation := "rolerewards:" + rewardSetHash(params.Rewards)
+		if params.ConfirmationToken == "" {
+			token, expires, err := confirmation.Issue(params.GuildID, params.UserID, operation)
+			if err != nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(writer, "Failed to issue confirmation token: ", err)
+				return
+			}
+			writer.WriteHeader(http.StatusOK)
+			json.NewEncoder(writer).Encode(ConfirmationRequired{Diff: diff, ConfirmationToken: token, ExpiresAt: expires})
+			return
+		}
+		err = confirmation.Verify(params.ConfirmationToken, params.GuildID, params.UserID, operation)
+		if errors.Is(err, confirmation.ErrInvalid) || errors.Is(err, confirmation.ErrExpired) {
+			writer.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(writer, "Failed to confirm operation: ", err)
+			return
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to confirm operation: ", err)
+			return
+		}
+	}
+
+	if !diff.empty() {
+		if err := applyRewardDiff(ctx, tx, params.GuildID, diff); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to push to DB: ", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	// Only published once committed, so the bot is never told about rewards the DB doesn't have.
+	if !diff.empty() {
+		if err := pushSetToRemraku(ctx, params.GuildID, params.Rewards, diff); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+			return
+		}
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	json.NewEncoder(writer).Encode(diff)
+
+}
+
+func validateRewards(rewards []RoleReward) (err error) {
+
+	if len(rewards) > maxRoleRewards {
+		return fmt.Errorf("A guild can have at most %d role rewards", maxRoleRewards)
+	}
+
+	seen := make(map[rewardKey]bool, len(rewards))
+	for _, reward := range rewards {
+		if _, err := strconv.ParseUint(reward.RoleID, 10, 64); err != nil || len(reward.RoleID) < 17 || len(reward.RoleID) > 20 {
+			return fmt.Errorf("Invalid role %q", reward.RoleID)
+		}
+		if reward.Level < 0 || reward.Level > levelcurve.MaxLevel {
+			return fmt.Errorf("Level %d of role %s is outside 0-%d", reward.Level, reward.RoleID, levelcurve.MaxLevel)
+		}
+		if err := reward.Expiry.validate(); err != nil {
+			return fmt.Errorf("Role %s at level %d: %s", reward.RoleID, reward.Level, err)
+		}
+		key := rewardKey{reward.RoleID, reward.Level}
+		if seen[key] {
+			return fmt.Errorf("Role %s is rewarded at level %d more than once", reward.RoleID, reward.Level)
+		}
+		seen[key] = true
+	}
+	return
+
+}
+
+func lockRoleRewards(ctx context.Context, tx pgx.Tx, guildID string) (rewards []RoleReward, err error) {
+
+	rows, err := tx.Query(ctx, "SELECT roleID, level, persistent, color, expiresAfter, topN, topWindowDays FROM rolerewards WHERE guildID = $1 ORDER BY level, roleID FOR UPDATE", guildID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reward RoleReward
+		err = rows.Scan(&reward.RoleID, &reward.Level, &reward.Persistent, &reward.Color, &reward.ExpiresAfter, &reward.TopN, &reward.TopWindowDays)
+		if err != nil {
+			return
+		}
+		rewards = append(rewards, reward)
+	}
+	err = rows.Err()
+	return
+
+}
+
+// diffRoleRewards works out what turns current into desired, rewards are identified by role and level.
+func diffRoleRewards(current []RoleReward, desired []RoleReward) (diff RoleRewardDiff) {
+
+	diff.Added = make([]RoleReward, 0)
+	diff.Updated = make([]RoleReward, 0)
+	diff.Removed = make([]RoleReward, 0)
+
+	existing := make(map[rewardKey]RoleReward, len(current))
+	for _, reward := range current {
+		existing[rewardKey{reward.RoleID, reward.Level}] = reward
+	}
+
+	kept := make(map[rewardKey]bool, len(desired))
+	for _, reward := range desired {
+		key := rewardKey{reward.RoleID, reward.Level}
+		kept[key] = true
+		old, ok := existing[key]
+		if !ok {
+			diff.Added = append(diff.Added, reward)
+			continue
+		}
+		if old.Persistent != reward.Persistent || !old.Expiry.equal(reward.Expiry) {
+			diff.Updated = append(diff.Updated, reward)
+		}
+	}
+
+	for _, reward := range current {
+		if !kept[rewardKey{reward.RoleID, reward.Level}] {
+			diff.Removed = append(diff.Removed, reward)
+		}
+	}
+	return
+
+}
+
+// checkChangedRewards validates the rewards that are new or changed and fills in every reward's color.
+// Rewards that stay as they are are not checked, so rewards for deleted roles can still be kept or removed.
+func checkChangedRewards(ctx context.Context, guildID string, current []RoleReward, desired []RoleReward) (err error) {
+
+	existing := make(map[rewardKey]RoleReward, len(current))
+	for _, reward := range current {
+		existing[rewardKey{reward.RoleID, reward.Level}] = reward
+	}
+
+	var changed []RoleReward
+	var indices []int
+	for i, reward := range desired {
+		old, ok := existing[rewardKey{reward.RoleID, reward.Level}]
+		if ok && old.Persistent == reward.Persistent && old.Expiry.equal(reward.Expiry) {
+			desired[i].Color = old.Color
+			continue
+		}
+		changed = append(changed, reward)
+		indices = append(indices, i)
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	err = checkAssignable(ctx, guildID, changed)
+	if err != nil {
+		return
+	}
+	for j, i := range indices {
+		desired[i].Color = changed[j].Color
+	}
+	return
+
+}
+
+// sameRewards tells whether two lists of rewards, both ordered by level and role, are identical.
+func sameRewards(a []RoleReward, b []RoleReward) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].RoleID != b[i].RoleID || a[i].Level != b[i].Level || a[i].Color != b[i].Color || a[i].Persistent != b[i].Persistent || !a[i].Expiry.equal(b[i].Expiry) {
+			return false
+		}
+	}
+	return true
+}
+
+// rewardSetHash identifies a set of rewards regardless of order, so a confirmation only covers the set it was shown for.
+func rewardSetHash(rewards []RoleReward) string {
+
+	keys := make([]string, len(rewards))
+	for i, reward := range rewards {
+		keys[i] = fmt.Sprintf("%s:%d:%t:%s", reward.RoleID, reward.Level, reward.Persistent, reward.Expiry.key())
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+
+}
+
+func applyRewardDiff(ctx context.Context, tx pgx.Tx, guildID string, diff RoleRewardDiff) (err error) {
+
+	for _, reward := range diff.Removed {
+		_, err = tx.Exec(ctx, "DELETE FROM rolerewards WHERE guildID = $1 AND roleID = $2 AND level = $3", guildID, reward.RoleID, reward.Level)
+		if err != nil {
+			return
+		}
+	}
+	for _, reward := range diff.Updated {
+		_, err = tx.Exec(ctx, "UPDATE rolerewards SET persistent = $4, color = $5, expiresAfter = $6, topN = $7, topWindowDays = $8 WHERE guildID = $1 AND roleID = $2 AND level = $3",
+			guildID, reward.RoleID, reward.Level, reward.Persistent, reward.Color, reward.ExpiresAfter, reward.TopN, reward.TopWindowDays)
+		if err != nil {
+			return
+		}
+	}
+	for _, reward := range diff.Added {
+		_, err = tx.Exec(ctx, "INSERT INTO rolerewards (guildID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+			guildID, reward.RoleID, reward.Level, reward.Color, reward.Persistent, reward.ExpiresAfter, reward.TopN, reward.TopWindowDays)
+		if err != nil {
+			return
+		}
+	}
+	return
+
+}
+
+type rolerewardSetMessage struct {
+	Type    string       `json:"type"`
+	GuildID string       `json:"guildID"`
+	Rewards []RoleReward `json:"rewards"`
+	RoleRewardDiff
+}
+
+func pushSetToRemraku(ctx context.Context, guildID string, rewards []RoleReward, diff RoleRewardDiff) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(rolerewardSetMessage{
+		Type:           "rolerewards",
+		GuildID:        guildID,
+		Rewards:        rewards,
+		RoleRewardDiff: diff,
+	})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(ctx, m).Get(ctx)
+
+	return
+
+}
@@ -0,0 +1,121 @@
+package remrolereward
+
+import (
+	"testing"
+
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+func TestDiffRoleRewards(t *testing.T) {
+
+	current := []RoleReward{
+		{RoleID: "956209277926768700", Level: 5},
+		{RoleID: "956209277926768701", Level: 10, Persistent: true},
+		{RoleID: "956209277926768702", Level: 20},
+	}
+	desired := []RoleReward{
+		{RoleID: "956209277926768700", Level: 5},
+		{RoleID: "956209277926768701", Level: 10},
+		{RoleID: "956209277926768702", Level: 30},
+	}
+
+	diff := diffRoleRewards(current, desired)
+	if len(diff.Added) != 1 || diff.Added[0].Level != 30 {
+		t.Errorf("Expected the level 30 reward to be added, got %+v\n", diff.Added)
+	}
+	if len(diff.Updated) != 1 || diff.Updated[0].RoleID != "956209277926768701" || diff.Updated[0].Persistent {
+		t.Errorf("Expected the level 10 reward to stop being persistent, got %+v\n", diff.Updated)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Level != 20 {
+		t.Errorf("Expected the level 20 reward to be removed, got %+v\n", diff.Removed)
+	}
+
+	if diff = diffRoleRewards(current, current); !diff.empty() {
+		t.Errorf("Expected no changes, got %+v\n", diff)
+	}
+
+}
+
+func TestValidateRewards(t *testing.T) {
+
+	if err := validateRewards([]RoleReward{{RoleID: "956209277926768700", Level: 5}, {RoleID: "956209277926768700", Level: 10}}); err != nil {
+		t.Errorf("Expected valid rewards, got %s\n", err)
+	}
+
+	invalid := [][]RoleReward{
+		{{RoleID: "", Level: 5}},
+		{{RoleID: "not a role", Level: 5}},
+		{{RoleID: "956209277926768700", Level: -1}},
+		{{RoleID: "956209277926768700", Level: levelcurve.MaxLevel + 1}},
+		{{RoleID: "956209277926768700", Level: 5}, {RoleID: "956209277926768700", Level: 5, Persistent: true}},
+		make([]RoleReward, maxRoleRewards+1),
+	}
+	for _, rewards := range invalid {
+		if err := validateRewards(rewards); err == nil {
+			t.Errorf("Expected error for %+v\n", rewards)
+		}
+	}
+
+}
+
+func TestRewardSetHash(t *testing.T) {
+
+	a := []RoleReward{{RoleID: "956209277926768700", Level: 5}, {RoleID: "956209277926768701", Level: 10}}
+	b := []RoleReward{{RoleID: "956209277926768701", Level: 10}, {RoleID: "956209277926768700", Level: 5}}
+	if rewardSetHash(a) != rewardSetHash(b) {
+		t.Errorf("Expected order not to matter\n")
+	}
+	b[0].Persistent = true
+	if rewardSetHash(a) == rewardSetHash(b) {
+		t.Errorf("Expected different sets to hash differently\n")
+	}
+
+}
+
+func TestSameRewards(t *testing.T) {
+
+	days := 30
+	a := []RoleReward{{RoleID: "956209277926768700", Level: 5, Color: 255}}
+	if !sameRewards(a, []RoleReward{{RoleID: "956209277926768700", Level: 5, Color: 255}}) || !sameRewards(nil, []RoleReward{}) {
+		t.Errorf("Expected identical rewards to match\n")
+	}
+	for _, b := range [][]RoleReward{
+		{},
+		{{RoleID: "956209277926768700", Level: 5, Color: 0}},
+		{{RoleID: "956209277926768700", Level: 5, Color: 255, Expiry: Expiry{ExpiresAfter: &days}}},
+	} {
+		if sameRewards(a, b) {
+			t.Errorf("Expected %+v not to match %+v\n", a, b)
+		}
+	}
+
+}
+
+func TestDiffRoleRewardExpiry(t *testing.T) {
+
+	days := 30
+	current := []RoleReward{{RoleID: "956209277926768700", Level: 5}}
+	desired := []RoleReward{{RoleID: "956209277926768700", Level: 5, Expiry: Expiry{ExpiresAfter: &days}}}
+
+	diff := diffRoleRewards(current, desired)
+	if len(diff.Updated) != 1 {
+		t.Errorf("Expected adding an expiry to update the reward, got %+v\n", diff)
+	}
+	if rewardSetHash(current) == rewardSetHash(desired) {
+		t.Errorf("Expected the expiry to be part of the set hash\n")
+	}
+
+	top := 10
+	invalid := []Expiry{
+		{ExpiresAfter: new(int)},
+		{TopN: new(int)},
+		{TopWindowDays: &days},
+		{TopN: &top, TopWindowDays: new(int)},
+	}
+	for _, e := range invalid {
+		if err := e.validate(); err == nil {
+			t.Errorf("Expected error for %s\n", e.key())
+		}
+	}
+
+}
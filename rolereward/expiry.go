@@ -0,0 +1,62 @@
+package remrolereward
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	maxExpiresAfter  = 366 * 24 * 60 * 60
+	maxTopN          = 1000
+	maxTopWindowDays = 366
+)
+
+// Expiry is when a reward is taken back again by the role-sync expiry job, rewards without any are permanent.
+type Expiry struct {
+	// ExpiresAfter is how many seconds members keep the role once it is handed out.
+	ExpiresAfter *int `json:"expiresAfter,omitempty"`
+	// TopN limits the role to members ranked this high on the leaderboard.
+	TopN *int `json:"topN,omitempty"`
+	// TopWindowDays ranks by xp gained over this many days instead of total xp.
+	TopWindowDays *int `json:"topWindowDays,omitempty"`
+}
+
+func (e Expiry) validate() error {
+	if e.ExpiresAfter != nil && (*e.ExpiresAfter <= 0 || *e.ExpiresAfter > maxExpiresAfter) {
+		return fmt.Errorf("expiresAfter must be between 1 and %d seconds", maxExpiresAfter)
+	}
+	if e.TopN != nil && (*e.TopN <= 0 || *e.TopN > maxTopN) {
+		return fmt.Errorf("topN must be between 1 and %d", maxTopN)
+	}
+	if e.TopWindowDays != nil {
+		if e.TopN == nil {
+			return errors.New("topWindowDays needs topN")
+		}
+		if *e.TopWindowDays <= 0 || *e.TopWindowDays > maxTopWindowDays {
+			return fmt.Errorf("topWindowDays must be between 1 and %d", maxTopWindowDays)
+		}
+	}
+	return nil
+}
+
+func (e Expiry) equal(o Expiry) bool {
+	return equalInt(e.ExpiresAfter, o.ExpiresAfter) && equalInt(e.TopN, o.TopN) && equalInt(e.TopWindowDays, o.TopWindowDays)
+}
+
+func equalInt(a *int, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (e Expiry) key() string {
+	return fmt.Sprintf("%s/%s/%s", optionalInt(e.ExpiresAfter), optionalInt(e.TopN), optionalInt(e.TopWindowDays))
+}
+
+func optionalInt(i *int) string {
+	if i == nil {
+		return "-"
+	}
+	return fmt.Sprint(*i)
+}
@@ -0,0 +1,148 @@
+package remrolereward
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+type RoleReward struct {
+	RoleID     string `json:"roleID"`
+	Level      int    `json:"level"`
+	Persistent bool   `json:"persistent"`
+	Color      int    `json:"color"`
+	Expiry
+}
+
+// ListedRoleReward is how rewards are listed, only the list looks the roles up on Discord.
+type ListedRoleReward struct {
+	RoleReward
+	// Exists is false once the role has been deleted on Discord, the reward then does nothing.
+	Exists bool `json:"exists"`
+}
+
+type DiscordRole struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Color    int    `json:"color"`
+	Position int    `json:"position"`
+	Managed  bool   `json:"managed"`
+}
+
+func getRoleRewards(writer http.ResponseWriter, request *http.Request) {
+
+	urlParams := request.URL.Query()
+	guildID := urlParams.Get("guildID")
+	userID := urlParams.Get("userID")
+	token, err := strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil || guildID == "" || userID == "" || token == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(guildID, userID, token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	rewards, err := fetchRoleRewards(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch role rewards: ", err)
+		return
+	}
+
+	roles, err := fetchGuildRoles(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch guild roles: ", err)
+		return
+	}
+	jsonResponse, err := json.Marshal(markExisting(rewards, roles))
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func fetchRoleRewards(ctx context.Context, guildID string) (rewards []RoleReward, err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	rows, err := pool.Query(ctx, "SELECT roleID, level, persistent, color, expiresAfter, topN, topWindowDays FROM rolerewards WHERE guildID = $1 ORDER BY level, roleID", guildID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	rewards = make([]RoleReward, 0)
+	for rows.Next() {
+		var reward RoleReward
+		err = rows.Scan(&reward.RoleID, &reward.Level, &reward.Persistent, &reward.Color, &reward.ExpiresAfter, &reward.TopN, &reward.TopWindowDays)
+		if err != nil {
+			return
+		}
+		rewards = append(rewards, reward)
+	}
+	err = rows.Err()
+	return
+
+}
+
+func fetchGuildRoles(ctx context.Context, guildID string) (roles []DiscordRole, err error) {
+	err = discordGet(ctx, fmt.Sprintf("/guilds/%s/roles", guildID), &roles)
+	return
+}
+
+func discordGet(ctx context.Context, path string, v interface{}) (err error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, os.Getenv("DISCORD_BASE_URI")+path, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bot %s", os.Getenv("DISCORD_TOKEN")))
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Discord responded with " + resp.Status)
+		return
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return
+
+}
+
+func markExisting(rewards []RoleReward, roles []DiscordRole) (listed []ListedRoleReward) {
+
+	existing := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		existing[role.ID] = true
+	}
+	listed = make([]ListedRoleReward, 0, len(rewards))
+	for _, reward := range rewards {
+		listed = append(listed, ListedRoleReward{RoleReward: reward, Exists: existing[reward.RoleID]})
+	}
+	return
+
+}
@@ -0,0 +1,48 @@
+package remrolereward
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFetchGuildRoles(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/guilds/719255152170762301/roles" || request.Header.Get("Authorization") != "Bot test" {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(writer, `[{"id":"719255152170762301","name":"@everyone","color":0,"position":0},{"id":"956209277926768700","name":"Regular","color":3447003,"position":3}]`)
+	}))
+	defer server.Close()
+
+	baseURI, token := os.Getenv("DISCORD_BASE_URI"), os.Getenv("DISCORD_TOKEN")
+	os.Setenv("DISCORD_BASE_URI", server.URL)
+	os.Setenv("DISCORD_TOKEN", "test")
+	defer os.Setenv("DISCORD_BASE_URI", baseURI)
+	defer os.Setenv("DISCORD_TOKEN", token)
+
+	roles, err := fetchGuildRoles(context.Background(), "719255152170762301")
+	if err != nil {
+		t.Errorf("Failed to fetch roles: %s\n", err)
+		return
+	}
+	if len(roles) != 2 || roles[1].Color != 3447003 || roles[1].Position != 3 {
+		t.Errorf("Unexpected roles: %+v\n", roles)
+	}
+
+	rewards := []RoleReward{{RoleID: "956209277926768700", Level: 5}, {RoleID: "956209277926768701", Level: 10}}
+	listed := markExisting(rewards, roles)
+	if !listed[0].Exists || listed[1].Exists {
+		t.Errorf("Expected only the first reward's role to exist, got %+v\n", listed)
+	}
+
+	if _, err = fetchGuildRoles(context.Background(), "719255152170762302"); err == nil {
+		t.Errorf("Expected error for unknown guild\n")
+	}
+
+}
@@ -0,0 +1,107 @@
+package remrolereward
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Codes for rewards the bot could never hand out.
+const (
+	rewardErrorUnknownRole = "unknownRole"
+	rewardErrorEveryone    = "everyoneRole"
+	rewardErrorManaged     = "managedRole"
+	rewardErrorAboveBot    = "roleAboveBot"
+)
+
+type RewardError struct {
+	RoleID  string `json:"roleID"`
+	Level   int    `json:"level"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// RewardValidationError lists every reward that cannot be assigned.
+type RewardValidationError struct {
+	Errors []RewardError `json:"errors"`
+}
+
+func (e *RewardValidationError) Error() string {
+	return fmt.Sprintf("%d role rewards cannot be assigned", len(e.Errors))
+}
+
+type discordMember struct {
+	Roles []string `json:"roles"`
+}
+
+// checkAssignable makes sure the bot can hand out every reward and copies each role's color onto it.
+func checkAssignable(ctx context.Context, guildID string, rewards []RoleReward) (err error) {
+
+	roles, err := fetchGuildRoles(ctx, guildID)
+	if err != nil {
+		return
+	}
+
+	// The bot's user ID is the same as its application's.
+	var bot discordMember
+	err = discordGet(ctx, fmt.Sprintf("/guilds/%s/members/%s", guildID, os.Getenv("DISCORD_CLIENT_ID")), &bot)
+	if err != nil {
+		return
+	}
+
+	return validateAssignable(guildID, rewards, roles, bot.Roles)
+
+}
+
+func validateAssignable(guildID string, rewards []RoleReward, roles []DiscordRole, botRoles []string) (err error) {
+
+	byID := make(map[string]DiscordRole, len(roles))
+	for _, role := range roles {
+		byID[role.ID] = role
+	}
+
+	// Bots can only assign roles below their own highest role.
+	botPosition := 0
+	for _, roleID := range botRoles {
+		if role, ok := byID[roleID]; ok && role.Position > botPosition {
+			botPosition = role.Position
+		}
+	}
+
+	var validationErr RewardValidationError
+	for i, reward := range rewards {
+		role, ok := byID[reward.RoleID]
+		switch {
+		case !ok:
+			validationErr.add(reward, rewardErrorUnknownRole, fmt.Sprintf("Role %s does not exist on the guild", reward.RoleID))
+			break
+		case role.ID == guildID:
+			validationErr.add(reward, rewardErrorEveryone, "Everyone already has the @everyone role")
+			break
+		case role.Managed:
+			validationErr.add(reward, rewardErrorManaged, fmt.Sprintf("Role %s is managed by an integration and cannot be assigned", role.Name))
+			break
+		case role.Position >= botPosition:
+			validationErr.add(reward, rewardErrorAboveBot, fmt.Sprintf("Role %s is not below Rem's highest role", role.Name))
+			break
+		default:
+			rewards[i].Color = role.Color
+			break
+		}
+	}
+
+	if len(validationErr.Errors) > 0 {
+		err = &validationErr
+	}
+	return
+
+}
+
+func (e *RewardValidationError) add(reward RoleReward, code string, message string) {
+	e.Errors = append(e.Errors, RewardError{
+		RoleID:  reward.RoleID,
+		Level:   reward.Level,
+		Code:    code,
+		Message: message,
+	})
+}
@@ -0,0 +1,53 @@
+package remrolereward
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateAssignable(t *testing.T) {
+
+	guildID := "719255152170762301"
+	roles := []DiscordRole{
+		{ID: guildID, Name: "@everyone", Position: 0},
+		{ID: "956209277926768700", Name: "Regular", Color: 3447003, Position: 1},
+		{ID: "956209277926768701", Name: "Rem", Position: 2, Managed: true},
+		{ID: "956209277926768702", Name: "Nitro Booster", Position: 1, Managed: true},
+		{ID: "956209277926768703", Name: "Admin", Position: 3},
+	}
+	botRoles := []string{"956209277926768701"}
+
+	rewards := []RoleReward{{RoleID: "956209277926768700", Level: 5}}
+	if err := validateAssignable(guildID, rewards, roles, botRoles); err != nil {
+		t.Errorf("Expected reward to be assignable, got %s\n", err)
+	}
+	if rewards[0].Color != 3447003 {
+		t.Errorf("Expected color to be filled in, got %d\n", rewards[0].Color)
+	}
+
+	rewards = []RoleReward{
+		{RoleID: "956209277926768799", Level: 5},
+		{RoleID: guildID, Level: 5},
+		{RoleID: "956209277926768702", Level: 5},
+		{RoleID: "956209277926768703", Level: 5},
+		{RoleID: "956209277926768701", Level: 5},
+	}
+	err := validateAssignable(guildID, rewards, roles, botRoles)
+	var validationErr *RewardValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Expected validation error, got %v\n", err)
+		return
+	}
+
+	expected := []string{rewardErrorUnknownRole, rewardErrorEveryone, rewardErrorManaged, rewardErrorAboveBot, rewardErrorManaged}
+	if len(validationErr.Errors) != len(expected) {
+		t.Errorf("Expected %d errors, got %+v\n", len(expected), validationErr.Errors)
+		return
+	}
+	for i, code := range expected {
+		if validationErr.Errors[i].Code != code {
+			t.Errorf("Reward %d: expected %s, got %s\n", i, code, validationErr.Errors[i].Code)
+		}
+	}
+
+}
@@ -57,12 +57,33 @@ type Params struct {
 	Level      int    `json:"level"`
 	Persistent bool   `json:"persistent"`
 	State      bool   `json:"state"`
+	Expiry
 }
 
 func roleReward(writer http.ResponseWriter, request *http.Request) {
 
 	corsHandler(writer, request)
 
+	switch request.Method {
+	case "GET":
+		getRoleRewards(writer, request)
+		break
+	case "POST":
+		postRoleReward(writer, request)
+		break
+	case "PUT":
+		putRoleRewards(writer, request)
+		break
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(writer, "Method not allowed")
+		break
+	}
+
+}
+
+func postRoleReward(writer http.ResponseWriter, request *http.Request) {
+
 	var params Params
 
 	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
@@ -82,13 +103,38 @@ func roleReward(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	if err := pushToDB(params.GuildID, params.RoleID, params.Level, params.Persistent, params.State, request); err != nil {
+	if err := params.Expiry.validate(); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+
+	// Removing a reward always works, even once its role is gone.
+	var color int
+	if params.State {
+		rewards := []RoleReward{{RoleID: params.RoleID, Level: params.Level, Persistent: params.Persistent, Expiry: params.Expiry}}
+		err := checkAssignable(request.Context(), params.GuildID, rewards)
+		var validationErr *RewardValidationError
+		if errors.As(err, &validationErr) {
+			writer.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(writer).Encode(validationErr)
+			return
+		}
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(writer, "Failed to fetch guild roles: ", err)
+			return
+		}
+		color = rewards[0].Color
+	}
+
+	if err := pushToDB(params.GuildID, params.RoleID, params.Level, color, params.Persistent, params.Expiry, params.State, request); err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(writer, "Failed to push to DB: ", err)
 		return
 	}
 
-	if err := pushToRemraku(params.GuildID, params.RoleID, params.Level, params.Persistent, params.State, request); err != nil {
+	if err := pushToRemraku(params.GuildID, params.RoleID, params.Level, params.Persistent, params.Expiry, params.State, request); err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
 		return
@@ -115,7 +161,7 @@ func confirmPermission(guildID string, callerID string, token int64) (err error)
 	return
 }
 
-func pushToDB(guildID string, roleID string, level int, persistent bool, state bool, request *http.Request) (err error) {
+func pushToDB(guildID string, roleID string, level int, color int, persistent bool, expiry Expiry, state bool, request *http.Request) (err error) {
 
 	err = createPool()
 	if err != nil {
@@ -123,7 +169,9 @@ func pushToDB(guildID string, roleID string, level int, persistent bool, state b
 	}
 
 	if state {
-		_, err = pool.Exec(request.Context(), "INSERT INTO roleRewards (guildID, roleID, level, persistent) VALUES ($1, $2, $3, $4) ON CONFLICT (guildID, roleID, level) DO UPDATE SET persistent = $4", guildID, roleID, level, persistent)
+		_, err = pool.Exec(request.Context(), `INSERT INTO roleRewards (guildID, roleID, level, color, persistent, expiresAfter, topN, topWindowDays) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (guildID, roleID, level) DO UPDATE SET color = $4, persistent = $5, expiresAfter = $6, topN = $7, topWindowDays = $8`,
+			guildID, roleID, level, color, persistent, expiry.ExpiresAfter, expiry.TopN, expiry.TopWindowDays)
 	} else {
 		_, err = pool.Exec(request.Context(), "DELETE FROM roleRewards WHERE guildID = $1 AND roleID = $2 AND level = $3", guildID, roleID, level)
 	}
@@ -138,9 +186,10 @@ type rolerewardMessage struct {
 	Level      int    `json:"level"`
 	Persistent bool   `json:"persistent"`
 	State      bool   `json:"state"`
+	Expiry
 }
 
-func pushToRemraku(guildID string, roleID string, level int, persistent bool, state bool, request *http.Request) (err error) {
+func pushToRemraku(guildID string, roleID string, level int, persistent bool, expiry Expiry, state bool, request *http.Request) (err error) {
 
 	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
 	if err != nil {
@@ -154,6 +203,7 @@ func pushToRemraku(guildID string, roleID string, level int, persistent bool, st
 		Level:      level,
 		Persistent: persistent,
 		State:      state,
+		Expiry:     expiry,
 	})
 	if err != nil {
 		return
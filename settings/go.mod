@@ -0,0 +1,13 @@
+module github.com/yayuyokitano/rem-next/settings
+
+go 1.16
+
+require (
+	cloud.google.com/go/kms v1.4.0 // indirect
+	cloud.google.com/go/pubsub v1.3.1
+	github.com/GoogleCloudPlatform/functions-framework-go v1.5.2
+	github.com/jackc/pgx/v4 v4.15.0
+	github.com/yayuyokitano/rem-next/levelcurve v0.0.0
+)
+
+replace github.com/yayuyokitano/rem-next/levelcurve => ../levelcurve
@@ -0,0 +1,343 @@
+package remsettings
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/yayuyokitano/rem-next/levelcurve"
+)
+
+const (
+	maxXPPerMessage      = 1000
+	maxCooldown          = 86400
+	maxLevelUpMessageLen = 2000
+)
+
+func init() {
+	functions.HTTP("settings", settings)
+}
+
+var client *pubsub.Client
+
+var pool *pgxpool.Pool
+
+func createPool() (err error) {
+	if pool == nil {
+		ctx := context.Background()
+
+		pool, err = pgxpool.Connect(ctx, os.Getenv("DATABASE_PRIVATE_URL"))
+	}
+	return
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func corsHandler(writer http.ResponseWriter, request *http.Request) {
+	allowed := strings.Split(os.Getenv("ALLOWED_ORIGINS"), "||")
+	origin := request.Header.Get("Origin")
+	if contains(allowed, origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+func isSnowflake(s string) bool {
+	if len(s) < 17 || len(s) > 20 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+type Settings struct {
+	XPMin             int               `json:"xpMin"`
+	XPMax             int               `json:"xpMax"`
+	Cooldown          int               `json:"cooldown"`
+	LevelUpMessage    string            `json:"levelUpMessage"`
+	AnnounceChannelID string            `json:"announceChannelID"`
+	PublicLeaderboard bool              `json:"publicLeaderboard"`
+	LevelCurve        levelcurve.Config `json:"levelCurve"`
+}
+
+// Matches the column defaults in guildsettings, used for guilds that never changed anything.
+var defaultSettings = Settings{
+	XPMin:      15,
+	XPMax:      25,
+	Cooldown:   60,
+	LevelCurve: levelcurve.DefaultConfig,
+}
+
+// Params is the PATCH body, settings left out of the request keep their current value.
+type Params struct {
+	GuildID           string             `json:"guildID"`
+	UserID            string             `json:"userID"`
+	Token             int64              `json:"token"`
+	XPMin             *int               `json:"xpMin"`
+	XPMax             *int               `json:"xpMax"`
+	Cooldown          *int               `json:"cooldown"`
+	LevelUpMessage    *string            `json:"levelUpMessage"`
+	AnnounceChannelID *string            `json:"announceChannelID"`
+	PublicLeaderboard *bool              `json:"publicLeaderboard"`
+	LevelCurve        *levelcurve.Config `json:"levelCurve"`
+}
+
+func settings(writer http.ResponseWriter, request *http.Request) {
+
+	corsHandler(writer, request)
+
+	switch request.Method {
+	case "GET":
+		getSettings(writer, request)
+		break
+	case "PATCH":
+		patchSettings(writer, request)
+		break
+	default:
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(writer, "Method not allowed")
+		break
+	}
+
+}
+
+func getSettings(writer http.ResponseWriter, request *http.Request) {
+
+	urlParams := request.URL.Query()
+	guildID := urlParams.Get("guildID")
+	userID := urlParams.Get("userID")
+	token, err := strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil || guildID == "" || userID == "" || token == 0 {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(guildID, userID, token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	current, err := fetchSettings(request.Context(), guildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch settings: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(current)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func patchSettings(writer http.ResponseWriter, request *http.Request) {
+
+	var params Params
+
+	if err := json.NewDecoder(request.Body).Decode(&params); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to decode request body", err)
+		return
+	}
+	if params.GuildID == "" || params.Token == 0 || params.UserID == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Missing parameters")
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.UserID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	current, err := fetchSettings(request.Context(), params.GuildID)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch settings: ", err)
+		return
+	}
+
+	updated := params.apply(current)
+	if err := updated.validate(); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid settings: ", err)
+		return
+	}
+
+	if err := pushToDB(params.GuildID, updated, request); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to DB: ", err)
+		return
+	}
+
+	if err := pushToRemraku(params.GuildID, updated, request); err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to push to Remraku: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(updated)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func (params Params) apply(s Settings) Settings {
+	if params.XPMin != nil {
+		s.XPMin = *params.XPMin
+	}
+	if params.XPMax != nil {
+		s.XPMax = *params.XPMax
+	}
+	if params.Cooldown != nil {
+		s.Cooldown = *params.Cooldown
+	}
+	if params.LevelUpMessage != nil {
+		s.LevelUpMessage = *params.LevelUpMessage
+	}
+	if params.AnnounceChannelID != nil {
+		s.AnnounceChannelID = *params.AnnounceChannelID
+	}
+	if params.PublicLeaderboard != nil {
+		s.PublicLeaderboard = *params.PublicLeaderboard
+	}
+	if params.LevelCurve != nil {
+		s.LevelCurve = *params.LevelCurve
+	}
+	return s
+}
+
+func (s Settings) validate() error {
+	if s.XPMin < 0 || s.XPMax > maxXPPerMessage {
+		return fmt.Errorf("XP per message must be between 0 and %d", maxXPPerMessage)
+	}
+	if s.XPMin > s.XPMax {
+		return errors.New("Minimum XP per message cannot exceed the maximum")
+	}
+	if s.Cooldown < 0 || s.Cooldown > maxCooldown {
+		return fmt.Errorf("Cooldown must be between 0 and %d seconds", maxCooldown)
+	}
+	if utf8.RuneCountInString(s.LevelUpMessage) > maxLevelUpMessageLen {
+		return fmt.Errorf("Level up message cannot exceed %d characters", maxLevelUpMessageLen)
+	}
+	if s.AnnounceChannelID != "" && !isSnowflake(s.AnnounceChannelID) {
+		return errors.New("Invalid announcement channel")
+	}
+	if _, err := s.LevelCurve.Curve(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func confirmPermission(guildID string, callerID string, token int64) (err error) {
+
+	client := &http.Client{}
+	resp, err := client.Post(os.Getenv("GCP_BASE_URI")+"/confirm-permission", "application/json", strings.NewReader(fmt.Sprintf(`{"guildID":"%s","userID":"%s","token":%d}`, guildID, callerID, token)))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Invalid token or user, or insufficient guild permissions: " + string(rawBody))
+		return
+	}
+	return
+}
+
+func fetchSettings(ctx context.Context, guildID string) (s Settings, err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	err = pool.QueryRow(ctx, "SELECT xpMin, xpMax, cooldown, levelUpMessage, announceChannelID, publicLeaderboard, levelCurve FROM guildsettings WHERE guildID = $1", guildID).Scan(&s.XPMin, &s.XPMax, &s.Cooldown, &s.LevelUpMessage, &s.AnnounceChannelID, &s.PublicLeaderboard, &s.LevelCurve)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s = defaultSettings
+		err = nil
+	}
+	return
+
+}
+
+func pushToDB(guildID string, s Settings, request *http.Request) (err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	_, err = pool.Exec(request.Context(), `INSERT INTO guildsettings (guildID, xpMin, xpMax, cooldown, levelUpMessage, announceChannelID, publicLeaderboard, levelCurve) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (guildID) DO UPDATE SET xpMin = $2, xpMax = $3, cooldown = $4, levelUpMessage = $5, announceChannelID = $6, publicLeaderboard = $7, levelCurve = $8`,
+		guildID, s.XPMin, s.XPMax, s.Cooldown, s.LevelUpMessage, s.AnnounceChannelID, s.PublicLeaderboard, s.LevelCurve)
+	return
+
+}
+
+type settingsMessage struct {
+	Type     string   `json:"type"`
+	GuildID  string   `json:"guildID"`
+	Settings Settings `json:"settings"`
+}
+
+func pushToRemraku(guildID string, s Settings, request *http.Request) (err error) {
+
+	client, err = pubsub.NewClient(context.Background(), os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		return
+	}
+
+	pubsubRaw, err := json.Marshal(settingsMessage{
+		Type:     "settings",
+		GuildID:  guildID,
+		Settings: s,
+	})
+	if err != nil {
+		return
+	}
+
+	m := &pubsub.Message{
+		Data: pubsubRaw,
+	}
+
+	_, err = client.Topic("remraku").Publish(request.Context(), m).Get(request.Context())
+
+	return
+
+}
@@ -0,0 +1,168 @@
+package remsettings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+)
+
+func TestSettings(t *testing.T) {
+
+	err := createPool()
+	if err != nil {
+		t.Errorf("Failed to create pool: %s\n", err)
+	}
+
+	var curSettings Settings
+	var curErr error
+	receivedMessage := false
+	ctx := context.Background()
+	testClient, err := pubsub.NewClient(ctx, os.Getenv("GCP_PROJECT_ID"))
+	if err != nil {
+		t.Errorf("pubsub.NewClient: %v", err)
+		return
+	}
+	defer testClient.Close()
+
+	sub := testClient.Subscription("remrakutest")
+	cctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	token, err := strconv.ParseInt(os.Getenv("REM_TEST_TOKEN"), 10, 64)
+	if err != nil {
+		t.Errorf("Failed to parse token: %s\n", err)
+		return
+	}
+
+	xpMin := 10
+	xpMax := 20
+	cooldown := 30
+	params := Params{
+		GuildID:  os.Getenv("REM_TEST_GUILDID"),
+		UserID:   os.Getenv("REM_TEST_USERID"),
+		Token:    token,
+		XPMin:    &xpMin,
+		XPMax:    &xpMax,
+		Cooldown: &cooldown,
+	}
+	jsonParams, err := json.Marshal(params)
+	if err != nil {
+		t.Errorf("Failed to marshal params: %s\n", err)
+		return
+	}
+
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest("PATCH", "/settings", bytes.NewReader(jsonParams))
+
+	settings(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusOK, writer.Code, writer.Body)
+		return
+	}
+
+	time.Sleep(2 * time.Second)
+
+	err = sub.Receive(cctx, func(_ context.Context, msg *pubsub.Message) {
+		receivedMessage = true
+		curSettings, curErr = handlePubsub(msg, t)
+	})
+	if err != nil {
+		t.Errorf("Receive: %v", err)
+		return
+	}
+
+	if curErr != nil || !receivedMessage {
+		t.Errorf("Failed to receive message: %s\n", curErr)
+		return
+	}
+
+	if curSettings.XPMin != xpMin || curSettings.XPMax != xpMax || curSettings.Cooldown != cooldown {
+		t.Errorf("Expected %d-%d every %ds, got %v\n", xpMin, xpMax, cooldown, curSettings)
+		return
+	}
+
+	query := fmt.Sprintf("/settings?guildID=%s&userID=%s&token=%s", os.Getenv("REM_TEST_GUILDID"), os.Getenv("REM_TEST_USERID"), os.Getenv("REM_TEST_TOKEN"))
+	writer = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", query, nil)
+
+	settings(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusOK, writer.Code, writer.Body)
+		return
+	}
+
+	if err := json.NewDecoder(writer.Body).Decode(&curSettings); err != nil {
+		t.Error("Failed to decode service response: ", err)
+		return
+	}
+
+	if curSettings.XPMin != xpMin || curSettings.XPMax != xpMax || curSettings.Cooldown != cooldown {
+		t.Errorf("Expected %d-%d every %ds, got %v\n", xpMin, xpMax, cooldown, curSettings)
+		return
+	}
+
+	// Only sending the minimum has to be checked against the stored maximum.
+	xpMin = 50
+	params = Params{
+		GuildID: params.GuildID,
+		UserID:  params.UserID,
+		Token:   token,
+		XPMin:   &xpMin,
+	}
+	jsonParams, err = json.Marshal(params)
+	if err != nil {
+		t.Errorf("Failed to marshal params: %s\n", err)
+		return
+	}
+
+	writer = httptest.NewRecorder()
+	request = httptest.NewRequest("PATCH", "/settings", bytes.NewReader(jsonParams))
+
+	settings(writer, request)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusBadRequest, writer.Code, writer.Body)
+		return
+	}
+
+	writer = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/settings", bytes.NewReader(jsonParams))
+
+	settings(writer, request)
+
+	if writer.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusMethodNotAllowed, writer.Code, writer.Body)
+		return
+	}
+
+}
+
+func handlePubsub(msg *pubsub.Message, t *testing.T) (s Settings, err error) {
+	msg.Ack()
+	var message settingsMessage
+	err = json.Unmarshal(msg.Data, &message)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("json.Unmarshal: %v", err))
+		return
+	}
+
+	if message.Type != "settings" || message.GuildID != os.Getenv("REM_TEST_GUILDID") {
+		err = errors.New(fmt.Sprintf("Invalid message: %v", message))
+		return
+	}
+
+	s = message.Settings
+	return
+}
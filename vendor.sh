@@ -0,0 +1,12 @@
+set -e
+
+# Cloud Functions only uploads the function's own directory, so any module
+# replaced with a sibling directory (e.g. ../levelcurve) has to be vendored first.
+for d in */ ; do
+  [[ $d == _* ]] && continue
+  grep -qs "=> \.\./" "${d%/}/go.mod" || continue
+  cd "${d%/}"
+
+  go mod vendor
+  cd ../
+done
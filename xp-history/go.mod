@@ -0,0 +1,8 @@
+module github.com/yayuyokitano/rem-next/xp-history
+
+go 1.16
+
+require (
+	github.com/GoogleCloudPlatform/functions-framework-go v1.5.2
+	github.com/jackc/pgx/v4 v4.15.0
+)
@@ -0,0 +1,218 @@
+package remxphistory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 100
+)
+
+func init() {
+	functions.HTTP("xp-history", xpHistory)
+}
+
+var pool *pgxpool.Pool
+
+func createPool() (err error) {
+	if pool == nil {
+		ctx := context.Background()
+
+		pool, err = pgxpool.Connect(ctx, os.Getenv("DATABASE_PRIVATE_URL"))
+	}
+	return
+}
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+func corsHandler(writer http.ResponseWriter, request *http.Request) {
+	allowed := strings.Split(os.Getenv("ALLOWED_ORIGINS"), "||")
+	origin := request.Header.Get("Origin")
+	if contains(allowed, origin) {
+		writer.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+type HistoryParams struct {
+	GuildID  string
+	UserID   string
+	CallerID string
+	Token    int64
+	Before   int64
+	Limit    int
+}
+
+type LedgerEntry struct {
+	ID        int64     `json:"id"`
+	Delta     int64     `json:"delta"`
+	Total     int64     `json:"total"`
+	Source    string    `json:"source"`
+	ActorID   *string   `json:"actorID"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type HistoryPage struct {
+	Entries []LedgerEntry `json:"entries"`
+	// Pass as before to fetch the next page, empty once the oldest entry has been returned.
+	NextCursor string `json:"nextCursor"`
+}
+
+func xpHistory(writer http.ResponseWriter, request *http.Request) {
+
+	corsHandler(writer, request)
+
+	params, err := parseParams(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(writer, "Invalid parameters: ", err)
+		return
+	}
+
+	if err := confirmPermission(params.GuildID, params.CallerID, params.Token); err != nil {
+		writer.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(writer, "Invalid permission: ", err)
+		return
+	}
+
+	page, err := getHistory(request.Context(), params)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to fetch history: ", err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(page)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(writer, "Failed to marshal response", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+	fmt.Fprint(writer, string(jsonResponse))
+
+}
+
+func parseParams(request *http.Request) (params HistoryParams, err error) {
+
+	urlParams := request.URL.Query()
+
+	params = HistoryParams{
+		GuildID:  urlParams.Get("guildID"),
+		UserID:   urlParams.Get("userID"),
+		CallerID: urlParams.Get("callerID"),
+		Limit:    defaultLimit,
+	}
+
+	params.Token, err = strconv.ParseInt(urlParams.Get("token"), 10, 64)
+	if err != nil {
+		err = errors.New("Invalid token")
+		return
+	}
+
+	if params.GuildID == "" || params.UserID == "" || params.CallerID == "" || params.Token == 0 {
+		err = errors.New("Missing parameters")
+		return
+	}
+
+	if before := urlParams.Get("before"); before != "" {
+		params.Before, err = strconv.ParseInt(before, 10, 64)
+		if err != nil || params.Before <= 0 {
+			err = errors.New("Invalid cursor")
+			return
+		}
+	}
+
+	if limit := urlParams.Get("limit"); limit != "" {
+		params.Limit, err = strconv.Atoi(limit)
+		if err != nil || params.Limit <= 0 || params.Limit > maxLimit {
+			err = fmt.Errorf("Limit must be between 1 and %d", maxLimit)
+			return
+		}
+	}
+
+	return
+
+}
+
+func confirmPermission(guildID string, callerID string, token int64) (err error) {
+
+	client := &http.Client{}
+	resp, err := client.Post(os.Getenv("GCP_BASE_URI")+"/confirm-permission", "application/json", strings.NewReader(fmt.Sprintf(`{"guildID":"%s","userID":"%s","token":%d}`, guildID, callerID, token)))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	rawBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = errors.New("Invalid token or user, or insufficient guild permissions: " + string(rawBody))
+		return
+	}
+	return
+}
+
+func getHistory(ctx context.Context, params HistoryParams) (page HistoryPage, err error) {
+
+	err = createPool()
+	if err != nil {
+		return
+	}
+
+	// Ledger ids only ever grow, so they double as a stable keyset cursor.
+	before := params.Before
+	if before == 0 {
+		before = 1<<63 - 1
+	}
+
+	// Fetch one extra row to find out whether there is another page.
+	rows, err := pool.Query(ctx, "SELECT id, delta, total, source, actorID, createdAt FROM xpledger WHERE guildID = $1 AND userID = $2 AND id < $3 ORDER BY id DESC LIMIT $4", params.GuildID, params.UserID, before, params.Limit+1)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	page.Entries = make([]LedgerEntry, 0, params.Limit)
+	for rows.Next() {
+		var entry LedgerEntry
+		err = rows.Scan(&entry.ID, &entry.Delta, &entry.Total, &entry.Source, &entry.ActorID, &entry.CreatedAt)
+		if err != nil {
+			return
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return
+	}
+
+	if len(page.Entries) > params.Limit {
+		page.Entries = page.Entries[:params.Limit]
+		page.NextCursor = strconv.FormatInt(page.Entries[params.Limit-1].ID, 10)
+	}
+
+	return
+
+}
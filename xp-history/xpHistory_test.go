@@ -0,0 +1,87 @@
+package remxphistory
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestXPHistory(t *testing.T) {
+
+	query := fmt.Sprintf("/xp-history?guildID=%s&userID=%s&callerID=%s&token=%s", os.Getenv("REM_TEST_GUILDID"), os.Getenv("REM_TEST_USERID"), os.Getenv("REM_TEST_USERID"), os.Getenv("REM_TEST_TOKEN"))
+	writer := httptest.NewRecorder()
+	request := httptest.NewRequest("GET", query+"&limit=2", nil)
+
+	xpHistory(writer, request)
+
+	if writer.Code != http.StatusOK {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusOK, writer.Code, writer.Body)
+		return
+	}
+
+	var page HistoryPage
+	if err := json.NewDecoder(writer.Body).Decode(&page); err != nil {
+		t.Error("Failed to decode service response: ", err)
+		return
+	}
+
+	if len(page.Entries) > 2 {
+		t.Errorf("Expected at most 2 entries, got %d\n", len(page.Entries))
+		return
+	}
+
+	for i := 1; i < len(page.Entries); i++ {
+		if page.Entries[i].ID >= page.Entries[i-1].ID {
+			t.Errorf("Entries are not ordered newest first: %d before %d\n", page.Entries[i-1].ID, page.Entries[i].ID)
+			return
+		}
+	}
+
+	if page.NextCursor != "" {
+		writer = httptest.NewRecorder()
+		request = httptest.NewRequest("GET", query+"&limit=2&before="+page.NextCursor, nil)
+
+		xpHistory(writer, request)
+
+		if writer.Code != http.StatusOK {
+			t.Errorf("Expected %d, got %d:%s\n", http.StatusOK, writer.Code, writer.Body)
+			return
+		}
+
+		var nextPage HistoryPage
+		if err := json.NewDecoder(writer.Body).Decode(&nextPage); err != nil {
+			t.Error("Failed to decode service response: ", err)
+			return
+		}
+
+		if len(nextPage.Entries) > 0 && nextPage.Entries[0].ID >= page.Entries[len(page.Entries)-1].ID {
+			t.Errorf("Next page overlaps previous page\n")
+			return
+		}
+	}
+
+	writer = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", query+"&limit=1000", nil)
+
+	xpHistory(writer, request)
+
+	if writer.Code != http.StatusBadRequest {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusBadRequest, writer.Code, writer.Body)
+		return
+	}
+
+	query = fmt.Sprintf("/xp-history?guildID=%s&userID=%s&callerID=%s&token=%s", os.Getenv("REM_TEST_GUILDID"), os.Getenv("REM_TEST_USERID"), "267794154459889664", os.Getenv("REM_TEST_TOKEN"))
+	writer = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", query, nil)
+
+	xpHistory(writer, request)
+
+	if writer.Code != http.StatusUnauthorized {
+		t.Errorf("Expected %d, got %d:%s\n", http.StatusUnauthorized, writer.Code, writer.Body)
+		return
+	}
+
+}